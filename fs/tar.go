@@ -32,23 +32,52 @@ func TarFromDir(output string, dir string, topDir string) error {
 	}
 	defer outFile.Close()
 
-	var (
-		isGzip    bool
-		tarWriter *tar.Writer
-	)
+	isGzip := strings.HasSuffix(output, ".tar.gz") || strings.HasSuffix(output, ".tgz")
 
-	isGzip = strings.HasSuffix(output, ".tar.gz") || strings.HasSuffix(output, ".tgz")
+	return TarDirToWriter(outFile, dir, topDir, isGzip)
+}
 
-	if isGzip {
-		gzipWriter := gzip.NewWriter(outFile)
-		defer gzipWriter.Close()
+// TarDirToWriter 将目录打包为 tar 流并写入 w，不产生临时文件。compress 为 true 时使用 gzip 压缩。
+// dir 与 topDir 的含义同 TarFromDir。
+func TarDirToWriter(w io.Writer, dir string, topDir string, compress bool) error {
+	if !IsDir(dir) {
+		return fmt.Errorf("directory does not exist: %s", dir)
+	}
 
+	var (
+		gzipWriter *gzip.Writer
+		tarWriter  *tar.Writer
+	)
+
+	if compress {
+		gzipWriter = gzip.NewWriter(w)
 		tarWriter = tar.NewWriter(gzipWriter)
 	} else {
-		tarWriter = tar.NewWriter(outFile)
+		tarWriter = tar.NewWriter(w)
+	}
+
+	if err := writeDirToTar(tarWriter, dir, topDir); err != nil {
+		_ = tarWriter.Close()
+		if gzipWriter != nil {
+			_ = gzipWriter.Close()
+		}
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("closing the tar writer failed: %w", err)
+	}
+	if gzipWriter != nil {
+		if err := gzipWriter.Close(); err != nil {
+			return fmt.Errorf("closing the gzip writer failed: %w", err)
+		}
 	}
-	defer tarWriter.Close()
 
+	return nil
+}
+
+// writeDirToTar 遍历目录并将所有条目写入 tarWriter。
+func writeDirToTar(tarWriter *tar.Writer, dir string, topDir string) error {
 	// 获取目录的绝对路径
 	absDir, err := filepath.Abs(dir)
 	if err != nil {
@@ -240,16 +269,18 @@ func Untar(filename string, outputDir string) error {
 	}
 	defer file.Close()
 
-	var (
-		isGzip    bool
-		tarReader *tar.Reader
-	)
+	isGzip := strings.HasSuffix(filename, ".tar.gz") || strings.HasSuffix(filename, ".tgz")
+
+	return UntarFromReader(file, outputDir, isGzip)
+}
 
-	isGzip = strings.HasSuffix(filename, ".tar.gz") || strings.HasSuffix(filename, ".tgz")
+// UntarFromReader 从 tar 流中解压文件到 outputDir (当目录不存在时，自动创建)。compress 为 true 时按 gzip 格式读取。
+func UntarFromReader(r io.Reader, outputDir string, compress bool) error {
+	var tarReader *tar.Reader
 
 	// 创建一个新的tar读取器
-	if isGzip {
-		gzipReader, err1 := gzip.NewReader(file)
+	if compress {
+		gzipReader, err1 := gzip.NewReader(r)
 		if err1 != nil {
 			return fmt.Errorf("failed to create the gzip reader: %w", err1)
 		}
@@ -257,8 +288,26 @@ func Untar(filename string, outputDir string) error {
 
 		tarReader = tar.NewReader(gzipReader)
 	} else {
-		tarReader = tar.NewReader(file)
+		tarReader = tar.NewReader(r)
+	}
+
+	absOutputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return fmt.Errorf("unable to get the absolute path to the directory: %w", err)
+	}
+	if err = os.MkdirAll(absOutputDir, os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create directory %s: %w", absOutputDir, err)
+	}
+	realOutputDir, err := filepath.EvalSymlinks(absOutputDir)
+	if err != nil {
+		return fmt.Errorf("unable to resolve directory %s: %w", absOutputDir, err)
 	}
+	// 目录和文件都经由 os.Root 创建，即使包内的符号链接指向别处，也不会写到目标目录之外
+	root, err := os.OpenRoot(realOutputDir)
+	if err != nil {
+		return fmt.Errorf("unable to open directory %s: %w", realOutputDir, err)
+	}
+	defer root.Close()
 
 	for {
 		header, err1 := tarReader.Next()
@@ -272,48 +321,114 @@ func Untar(filename string, outputDir string) error {
 			return fmt.Errorf("reading tar file entry failed: %w", err1)
 		}
 
-		// 构建完整的文件路径
-		filePath := filepath.Join(outputDir, header.Name)
+		// 构建完整的文件路径，并拒绝跳出目标目录的条目
+		filePath := filepath.Join(absOutputDir, header.Name)
+		if !isWithinDir(absOutputDir, filePath) {
+			return fmt.Errorf("illegal file path in tar: %s", header.Name)
+		}
+		rel, _ := filepath.Rel(absOutputDir, filePath)
 
 		switch header.Typeflag {
 		case tar.TypeDir:
 			// 创建目录
-			err = os.MkdirAll(filePath, os.FileMode(header.Mode))
+			err = mkdirAllInRoot(root, rel, os.FileMode(header.Mode).Perm())
 			if err != nil {
 				return fmt.Errorf("unable to create directory %s: %w", filePath, err)
 			}
 		case tar.TypeReg:
 			// 确保父目录存在
-			parentDir := filepath.Dir(filePath)
-			err = os.MkdirAll(parentDir, os.ModePerm)
+			err = mkdirAllInRoot(root, filepath.Dir(rel), os.ModePerm)
 			if err != nil {
-				return fmt.Errorf("unable to create parent directory %s: %w", parentDir, err)
+				return fmt.Errorf("unable to create parent directory %s: %w", filepath.Dir(filePath), err)
 			}
 
-			// 创建文件
-			outFile, err2 := os.Create(filePath)
+			// 与 tar 命令一致，先删除已存在的文件，以免经由已有的硬链接写入其他文件
+			if err = root.Remove(rel); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("unable to replace %s: %w", filePath, err)
+			}
+			outFile, err2 := root.OpenFile(rel, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
 			if err2 != nil {
 				return fmt.Errorf("unable to create file %s: %w", filePath, err2)
 			}
 
 			// 将tar中的内容写入文件
 			_, err = io.Copy(outFile, tarReader)
+			if err == nil {
+				// 设置文件权限
+				if err = outFile.Chmod(os.FileMode(header.Mode).Perm()); err != nil {
+					outFile.Close()
+					return fmt.Errorf("unable to set file permissions %s: %w", filePath, err)
+				}
+			}
 			outFile.Close()
 			if err != nil {
 				return fmt.Errorf("failed to write to file %s: %w", filePath, err)
 			}
+		case tar.TypeSymlink, tar.TypeLink:
+			// 链接目标同样不允许跳出目标目录：符号链接相对于其所在目录，硬链接相对于包的根目录
+			target := filepath.Join(filepath.Dir(filePath), header.Linkname)
+			if header.Typeflag == tar.TypeLink {
+				target = filepath.Join(absOutputDir, header.Linkname)
+			}
+			if filepath.IsAbs(header.Linkname) || !isWithinDir(absOutputDir, target) {
+				return fmt.Errorf("illegal link target in tar: %s -> %s", header.Name, header.Linkname)
+			}
 
-			// 设置文件权限
-			err = os.Chmod(filePath, os.FileMode(header.Mode))
+			err = mkdirAllInRoot(root, filepath.Dir(rel), os.ModePerm)
 			if err != nil {
-				return fmt.Errorf("unable to set file permissions %s: %w", filePath, err)
+				return fmt.Errorf("unable to create parent directory %s: %w", filepath.Dir(filePath), err)
+			}
+			// 链接只能按路径创建，因此路径中 (硬链接还包括源文件路径中) 不允许出现符号链接
+			linkPath := filepath.Join(realOutputDir, rel)
+			if !hasNoSymlinks(filepath.Dir(linkPath)) {
+				return fmt.Errorf("link %s is below a symbolic link", header.Name)
+			}
+			if err = root.Remove(rel); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("unable to replace %s: %w", filePath, err)
+			}
+			if header.Typeflag == tar.TypeSymlink {
+				err = os.Symlink(header.Linkname, linkPath)
+			} else {
+				targetRel, _ := filepath.Rel(absOutputDir, target)
+				src := filepath.Join(realOutputDir, targetRel)
+				if !hasNoSymlinks(src) {
+					return fmt.Errorf("illegal link target in tar: %s -> %s", header.Name, header.Linkname)
+				}
+				err = os.Link(src, linkPath)
+			}
+			if err != nil {
+				return fmt.Errorf("unable to create link %s: %w", filePath, err)
 			}
 		default:
-			// 处理其他类型的文件（如符号链接、设备文件等）
-			// 这里简单跳过不支持的类型
-			fmt.Printf("Unsupported file types: %v - %s\n", header.Typeflag, header.Name)
+			// 设备文件、FIFO 等类型不支持
+			return fmt.Errorf("unsupported tar entry type %q: %s", header.Typeflag, header.Name)
 		}
 	}
 
 	return nil
 }
+
+// isWithinDir 报告 p 是否为 dir 或位于 dir 之下 (仅按路径判断)。
+func isWithinDir(dir, p string) bool {
+	return p == dir || strings.HasPrefix(p, dir+string(os.PathSeparator))
+}
+
+// hasNoSymlinks 报告已存在的路径 p 的各级是否都不是符号链接。
+func hasNoSymlinks(p string) bool {
+	resolved, err := filepath.EvalSymlinks(p)
+	return err == nil && resolved == p
+}
+
+// mkdirAllInRoot 在 root 内逐级创建目录 dir (相对路径)，已存在的目录保持不变。
+func mkdirAllInRoot(root *os.Root, dir string, perm os.FileMode) error {
+	if dir == "." {
+		return nil
+	}
+	if err := mkdirAllInRoot(root, filepath.Dir(dir), os.ModePerm); err != nil {
+		return err
+	}
+	if err := root.Mkdir(dir, perm); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
@@ -1,6 +1,10 @@
 package fs_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/designinlife/slib/fs"
@@ -30,3 +34,74 @@ func TestUntar(t *testing.T) {
 	err = fs.Untar("D:\\tmp\\t2-dir.tar.gz", "D:\\tmp\\t2-dir-tar-gz")
 	assert.NoError(t, err)
 }
+
+func TestTarDirToWriter(t *testing.T) {
+	src := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "a", "b"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "a", "b", "x.txt"), []byte("hello"), 0o644))
+
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		assert.NoError(t, fs.TarDirToWriter(&buf, src, "", compress))
+
+		dst := t.TempDir()
+		assert.NoError(t, fs.UntarFromReader(&buf, dst, compress))
+
+		b, err := os.ReadFile(filepath.Join(dst, "a", "b", "x.txt"))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+	}
+}
+
+func TestUntarFromReaderLinks(t *testing.T) {
+	archive := func(headers ...*tar.Header) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, h := range headers {
+			assert.NoError(t, tw.WriteHeader(h))
+			if h.Typeflag == tar.TypeReg {
+				_, err := tw.Write([]byte("hello"))
+				assert.NoError(t, err)
+			}
+		}
+		assert.NoError(t, tw.Close())
+		return &buf
+	}
+
+	dst := t.TempDir()
+	err := fs.UntarFromReader(archive(
+		&tar.Header{Typeflag: tar.TypeDir, Name: "d/", Mode: 0o755},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "d/f", Mode: 0o640, Size: 5},
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "l", Linkname: "d/f"},
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "d/up", Linkname: "../l"},
+		&tar.Header{Typeflag: tar.TypeLink, Name: "h", Linkname: "d/f"},
+	), dst, false)
+	assert.NoError(t, err)
+	target, err := os.Readlink(filepath.Join(dst, "l"))
+	assert.NoError(t, err)
+	assert.Equal(t, "d/f", target)
+	for _, name := range []string{"l", "d/up", "h"} {
+		b, err1 := os.ReadFile(filepath.Join(dst, name))
+		assert.NoError(t, err1, name)
+		assert.Equal(t, "hello", string(b), name)
+	}
+
+	// links leaving the output directory and unsupported entries are errors
+	outside := t.TempDir()
+	for _, h := range []*tar.Header{
+		{Typeflag: tar.TypeSymlink, Name: "esc", Linkname: "../" + filepath.Base(outside)},
+		{Typeflag: tar.TypeSymlink, Name: "abs", Linkname: outside},
+		{Typeflag: tar.TypeLink, Name: "hard", Linkname: "../x"},
+		{Typeflag: tar.TypeFifo, Name: "fifo"},
+	} {
+		err = fs.UntarFromReader(archive(h), t.TempDir(), false)
+		assert.Error(t, err, h.Name)
+	}
+
+	// nothing is written through a symlink to a directory
+	dst = t.TempDir()
+	assert.NoError(t, os.Symlink(outside, filepath.Join(dst, "out")))
+	err = fs.UntarFromReader(archive(&tar.Header{Typeflag: tar.TypeReg, Name: "out/f", Mode: 0o644, Size: 5}), dst, false)
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(outside, "f"))
+}
@@ -0,0 +1,143 @@
+package net
+
+import (
+	"fmt"
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// ModernAlgorithms is a hardened preset: post-quantum and elliptic-curve key exchange, AEAD or CTR
+// ciphers with encrypt-then-MAC, and no SHA-1 anywhere. It fits current OpenSSH servers.
+func ModernAlgorithms() ssh.Algorithms {
+	return ssh.Algorithms{
+		KeyExchanges: []string{
+			ssh.KeyExchangeMLKEM768X25519,
+			ssh.KeyExchangeCurve25519,
+			ssh.KeyExchangeECDHP521,
+			ssh.KeyExchangeECDHP384,
+			ssh.KeyExchangeECDHP256,
+			ssh.KeyExchangeDH16SHA512,
+		},
+		Ciphers: []string{
+			ssh.CipherChaCha20Poly1305,
+			ssh.CipherAES256GCM,
+			ssh.CipherAES128GCM,
+			ssh.CipherAES256CTR,
+			ssh.CipherAES192CTR,
+			ssh.CipherAES128CTR,
+		},
+		MACs: []string{ssh.HMACSHA512ETM, ssh.HMACSHA256ETM},
+		HostKeys: []string{
+			ssh.CertAlgoED25519v01,
+			ssh.CertAlgoECDSA521v01,
+			ssh.CertAlgoECDSA384v01,
+			ssh.CertAlgoECDSA256v01,
+			ssh.CertAlgoRSASHA512v01,
+			ssh.CertAlgoRSASHA256v01,
+			ssh.KeyAlgoED25519,
+			ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoECDSA384,
+			ssh.KeyAlgoECDSA256,
+			ssh.KeyAlgoRSASHA512,
+			ssh.KeyAlgoRSASHA256,
+		},
+	}
+}
+
+// LegacyAlgorithms is a compatibility preset for old network devices and appliances: everything the
+// library implements, secure algorithms first, followed by the insecure ones such as
+// diffie-hellman-group1-sha1, aes128-cbc, 3des-cbc and ssh-rsa.
+func LegacyAlgorithms() ssh.Algorithms {
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	return ssh.Algorithms{
+		KeyExchanges: append(supported.KeyExchanges, insecure.KeyExchanges...),
+		Ciphers:      append(supported.Ciphers, insecure.Ciphers...),
+		MACs:         append(supported.MACs, insecure.MACs...),
+		HostKeys:     append(supported.HostKeys, insecure.HostKeys...),
+	}
+}
+
+// WithAlgorithms sets the offered algorithms from a preset or a custom set; empty lists keep
+// the library defaults.
+func WithAlgorithms(a ssh.Algorithms) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.KeyExchanges = a.KeyExchanges
+		c.Ciphers = a.Ciphers
+		c.MACs = a.MACs
+		c.HostKeyAlgorithms = a.HostKeys
+	}
+}
+
+func WithCiphers(ciphers ...string) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.Ciphers = ciphers
+	}
+}
+
+func WithKeyExchanges(kex ...string) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.KeyExchanges = kex
+	}
+}
+
+func WithMACs(macs ...string) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.MACs = macs
+	}
+}
+
+func WithHostKeyAlgorithms(algos ...string) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.HostKeyAlgorithms = algos
+	}
+}
+
+// checkAlgorithms rejects names the library does not implement, which it would otherwise drop silently.
+func (c *RichSSHClient) checkAlgorithms() error {
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	// the defaults include some names (e.g. legacy certificate types) listed in neither set
+	hostKeys := append(append(supported.HostKeys, insecure.HostKeys...), ssh.CertAlgoRSAv01, ssh.InsecureCertAlgoDSAv01)
+	for _, check := range []struct {
+		kind    string
+		names   []string
+		allowed []string
+	}{
+		{"key exchange", c.KeyExchanges, append(supported.KeyExchanges, insecure.KeyExchanges...)},
+		{"cipher", c.Ciphers, append(supported.Ciphers, insecure.Ciphers...)},
+		{"mac", c.MACs, append(supported.MACs, insecure.MACs...)},
+		{"host key algorithm", c.HostKeyAlgorithms, hostKeys},
+	} {
+		for _, name := range check.names {
+			if !slices.Contains(check.allowed, name) {
+				return fmt.Errorf("unsupported %s %s", check.kind, name)
+			}
+		}
+	}
+	return nil
+}
+
+// ConnectionInfo describes an established connection.
+type ConnectionInfo struct {
+	ServerVersion string // e.g. "SSH-2.0-OpenSSH_9.6"
+	ClientVersion string
+	// Algorithms are the negotiated key exchange, host key, and per-direction cipher and MAC.
+	Algorithms ssh.NegotiatedAlgorithms
+}
+
+// ConnectionInfo reports the server version and negotiated algorithms of the current connection.
+func (c *RichSSHClient) ConnectionInfo() (*ConnectionInfo, error) {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+	if client == nil {
+		return nil, errors.New("ssh client not connected")
+	}
+	info := &ConnectionInfo{ServerVersion: string(client.ServerVersion()), ClientVersion: string(client.ClientVersion())}
+	if meta, ok := client.Conn.(ssh.AlgorithmsConnMetadata); ok {
+		info.Algorithms = meta.Algorithms()
+	}
+	return info, nil
+}
@@ -0,0 +1,53 @@
+package net_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/designinlife/slib/net"
+	"github.com/designinlife/slib/net/sshtest"
+)
+
+func TestRichSSHClientAlgorithms(t *testing.T) {
+	srv := newTestServer(t, sshtest.WithServerConfig(func(cfg *ssh.ServerConfig) {
+		cfg.ServerVersion = "SSH-2.0-TestSSH_1.0"
+		cfg.Ciphers = []string{ssh.CipherAES256CTR, ssh.CipherAES128CTR}
+		cfg.MACs = []string{ssh.HMACSHA256ETM}
+	}))
+	c := newTestClient(t, srv, net.WithAlgorithms(net.ModernAlgorithms()), net.WithCiphers(ssh.CipherAES128CTR))
+	require.NoError(t, c.Connect(context.Background()))
+
+	info, err := c.ConnectionInfo()
+	require.NoError(t, err)
+	assert.Equal(t, "SSH-2.0-TestSSH_1.0", info.ServerVersion)
+	assert.Equal(t, ssh.CipherAES128CTR, info.Algorithms.Write.Cipher)
+	assert.Equal(t, ssh.HMACSHA256ETM, info.Algorithms.Read.MAC)
+	assert.Equal(t, ssh.KeyAlgoED25519, info.Algorithms.HostKey)
+	assert.Contains(t, net.ModernAlgorithms().KeyExchanges, info.Algorithms.KeyExchange)
+
+	_, err = newTestClient(t, srv).ConnectionInfo()
+	assert.Error(t, err)
+}
+
+func TestRichSSHClientLegacyAlgorithms(t *testing.T) {
+	srv := newTestServer(t, sshtest.WithServerConfig(func(cfg *ssh.ServerConfig) {
+		cfg.KeyExchanges = []string{ssh.InsecureKeyExchangeDH1SHA1}
+	}))
+	ctx := context.Background()
+
+	assert.Error(t, newTestClient(t, srv).Connect(ctx))
+	assert.Error(t, newTestClient(t, srv, net.WithAlgorithms(net.ModernAlgorithms())).Connect(ctx))
+
+	c := newTestClient(t, srv, net.WithAlgorithms(net.LegacyAlgorithms()))
+	require.NoError(t, c.Connect(ctx))
+	info, err := c.ConnectionInfo()
+	require.NoError(t, err)
+	assert.Equal(t, ssh.InsecureKeyExchangeDH1SHA1, info.Algorithms.KeyExchange)
+
+	err = newTestClient(t, srv, net.WithKeyExchanges("bogus-kex")).Connect(ctx)
+	assert.ErrorContains(t, err, "unsupported key exchange bogus-kex")
+}
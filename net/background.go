@@ -0,0 +1,226 @@
+package net
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// BackgroundState is the state of a detached process.
+type BackgroundState string
+
+const (
+	BackgroundRunning BackgroundState = "running"
+	BackgroundExited  BackgroundState = "exited"
+	// BackgroundLost means the process is gone without recording an exit code,
+	// e.g. after SIGKILL or a reboot.
+	BackgroundLost BackgroundState = "lost"
+)
+
+// BackgroundStatus is the result of BackgroundProcess.Status.
+type BackgroundStatus struct {
+	State    BackgroundState
+	ExitCode int // valid when State is BackgroundExited
+}
+
+// BackgroundOption controls StartBackground.
+type BackgroundOption struct {
+	// JobDir holds the pid, exit code and log files; it is created by `mktemp -d` when empty.
+	JobDir string
+	// WorkDir is the working directory of the command.
+	WorkDir string
+}
+
+// BackgroundProcess is a detached remote command started by StartBackground. Its state lives in
+// Dir on the remote host, so it can be picked up again with AttachBackground after reconnecting.
+type BackgroundProcess struct {
+	PID     int
+	Dir     string
+	LogFile string // stdout and stderr of the command
+
+	c *RichSSHClient
+}
+
+// backgroundExitScript ends the wrappers of StartBackground, recording the exit code in rc.
+const backgroundExitScript = `echo $rc > "$2/exit.tmp" && mv "$2/exit.tmp" "$2/exit"`
+
+// StartBackground starts cmd detached from the SSH session in a new session and process group
+// (setsid, or nohup where setsid is missing), with its output redirected to a log file.
+// The command keeps running when the connection drops. opt may be nil.
+func (c *RichSSHClient) StartBackground(ctx context.Context, cmd string, opt *BackgroundOption) (*BackgroundProcess, error) {
+	if opt == nil {
+		opt = &BackgroundOption{}
+	}
+
+	dir := `"$(mktemp -d "${TMPDIR:-/tmp}/bgjob.XXXXXX")"`
+	if opt.JobDir != "" {
+		dir = shellQuote(opt.JobDir)
+	}
+	inner := cmd
+	if opt.WorkDir != "" {
+		inner = "cd " + shellQuote(opt.WorkDir) + " && " + cmd
+	}
+	// The wrapper records the exit code; its TERM trap lets it outlive a group kill long enough
+	// to do so (a trap, unlike an ignored signal, is not inherited by the command).
+	wrapper := `trap : HUP INT TERM; echo $$ > "$2/pid.tmp" && mv "$2/pid.tmp" "$2/pid"; sh -c "$1"; rc=$?; ` + backgroundExitScript
+	// Without setsid the command shares the group of the wrapper, so Kill cannot signal the group.
+	// The command then runs as a child whose pid is recorded for Kill; being asynchronous, it
+	// ignores INT and QUIT.
+	childWrapper := `trap : HUP INT TERM; sh -c "$1" & c=$!; echo $c > "$2/child"; ` +
+		`echo $$ > "$2/pid.tmp" && mv "$2/pid.tmp" "$2/pid"; ` +
+		`while :; do wait $c; rc=$?; kill -0 $c 2>/dev/null || break; done; ` + backgroundExitScript
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "set -e; d=%s; mkdir -p \"$d\"; rm -f \"$d/pid\" \"$d/child\" \"$d/exit\"; cd /\n", dir)
+	fmt.Fprintf(&sb, "if command -v setsid >/dev/null 2>&1; then detach='setsid'; w=%s; else detach=''; w=%s; fi\n", shellQuote(wrapper), shellQuote(childWrapper))
+	fmt.Fprintf(&sb, "nohup $detach sh -c \"$w\" bgjob %s \"$d\" > \"$d/output.log\" 2>&1 < /dev/null &\n", shellQuote(inner))
+	// wait until the wrapper is set up, so the handle is immediately usable
+	sb.WriteString("i=0; while [ ! -s \"$d/pid\" ] && [ $i -lt 200 ]; do sleep 0.05 2>/dev/null || sleep 1; i=$((i+1)); done\n")
+	sb.WriteString("cat \"$d/pid\"; echo \"$d\"")
+
+	resp, err := c.Run(ctx, sb.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "start background command failed")
+	}
+	if resp.ExitCode != 0 {
+		return nil, fmt.Errorf("start background command failed with exit code %d: %s", resp.ExitCode, strings.TrimSpace(string(resp.Stderr)))
+	}
+	fields := strings.Split(strings.TrimSpace(string(resp.Stdout)), "\n")
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("start background command failed: unexpected output %q", resp.Stdout)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return nil, fmt.Errorf("start background command failed: invalid pid %q", fields[0])
+	}
+	jobDir := strings.TrimSpace(fields[1])
+	return &BackgroundProcess{PID: pid, Dir: jobDir, LogFile: path.Join(jobDir, "output.log"), c: c}, nil
+}
+
+// AttachBackground returns the handle of a process started by StartBackground with job directory dir,
+// possibly from another connection.
+func (c *RichSSHClient) AttachBackground(ctx context.Context, dir string) (*BackgroundProcess, error) {
+	resp, err := c.Run(ctx, "cat "+shellQuote(path.Join(dir, "pid")))
+	if err != nil {
+		return nil, err
+	}
+	if resp.ExitCode != 0 {
+		return nil, fmt.Errorf("no background job in %s: %s", dir, strings.TrimSpace(string(resp.Stderr)))
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(resp.Stdout)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pid file in %s", dir)
+	}
+	return &BackgroundProcess{PID: pid, Dir: dir, LogFile: path.Join(dir, "output.log"), c: c}, nil
+}
+
+// Status reports whether the process is still running and its exit code once it has exited.
+func (p *BackgroundProcess) Status(ctx context.Context) (*BackgroundStatus, error) {
+	exitFile := shellQuote(path.Join(p.Dir, "exit"))
+	cmd := fmt.Sprintf("if [ -f %[1]s ]; then echo exited; cat %[1]s; elif kill -0 %[2]d 2>/dev/null; then echo running; "+
+		"elif [ -f %[1]s ]; then echo exited; cat %[1]s; else echo lost; fi", exitFile, p.PID)
+	resp, err := p.c.Run(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(resp.Stdout))
+	if resp.ExitCode != 0 || len(fields) == 0 {
+		return nil, fmt.Errorf("background job status failed: %s", strings.TrimSpace(string(resp.Stderr)))
+	}
+	st := &BackgroundStatus{State: BackgroundState(fields[0])}
+	if st.State == BackgroundExited {
+		if len(fields) < 2 {
+			return nil, errors.New("background job status failed: empty exit file")
+		}
+		if st.ExitCode, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, fmt.Errorf("background job status failed: invalid exit code %q", fields[1])
+		}
+	}
+	return st, nil
+}
+
+// ReadLog returns the log output from offset on and the offset to pass next time.
+func (p *BackgroundProcess) ReadLog(ctx context.Context, offset int64) ([]byte, int64, error) {
+	resp, err := p.c.Run(ctx, fmt.Sprintf("tail -c +%d %s", offset+1, shellQuote(p.LogFile)))
+	if err != nil {
+		return nil, offset, err
+	}
+	if resp.ExitCode != 0 {
+		return nil, offset, fmt.Errorf("read log %s failed: %s", p.LogFile, strings.TrimSpace(string(resp.Stderr)))
+	}
+	return resp.Stdout, offset + int64(len(resp.Stdout)), nil
+}
+
+// Wait polls the status every interval (1s if <= 0) until the process is no longer running and
+// returns its exit code. A lost process is an error.
+func (p *BackgroundProcess) Wait(ctx context.Context, interval time.Duration) (int, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		st, err := p.Status(ctx)
+		if err != nil {
+			return -1, err
+		}
+		switch st.State {
+		case BackgroundExited:
+			return st.ExitCode, nil
+		case BackgroundLost:
+			return -1, fmt.Errorf("background process %d on %s is gone without an exit code", p.PID, p.c.Host)
+		}
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Kill sends signal (e.g. "TERM" or "KILL"; TERM if empty) to the process group of the command.
+// When it could not be started in its own group (no setsid on the host), only the command's
+// shell gets the signal: processes it started itself keep running.
+func (p *BackgroundProcess) Kill(ctx context.Context, signal string) error {
+	if signal == "" {
+		signal = "TERM"
+	}
+	if _, ok := signalNumbers[strings.TrimPrefix(signal, "SIG")]; !ok {
+		return fmt.Errorf("unsupported signal %s", signal)
+	}
+	signal = strings.TrimPrefix(signal, "SIG")
+	child := shellQuote(path.Join(p.Dir, "child"))
+	resp, err := p.c.Run(ctx, fmt.Sprintf("kill -%[1]s -%[2]d 2>/dev/null || if [ -s %[3]s ]; then kill -%[1]s \"$(cat %[3]s)\"; else kill -%[1]s %[2]d; fi",
+		signal, p.PID, child))
+	if err != nil {
+		return err
+	}
+	if resp.ExitCode != 0 {
+		return fmt.Errorf("kill background process %d failed: %s", p.PID, strings.TrimSpace(string(resp.Stderr)))
+	}
+	return nil
+}
+
+// Remove deletes the job directory, including the log. The process must not be running.
+func (p *BackgroundProcess) Remove(ctx context.Context) error {
+	st, err := p.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if st.State == BackgroundRunning {
+		return fmt.Errorf("background process %d is still running", p.PID)
+	}
+	resp, err := p.c.Run(ctx, "rm -rf "+shellQuote(p.Dir))
+	if err != nil {
+		return err
+	}
+	if resp.ExitCode != 0 {
+		return fmt.Errorf("remove %s failed: %s", p.Dir, strings.TrimSpace(string(resp.Stderr)))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package net_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+	"github.com/designinlife/slib/net/sshtest"
+)
+
+func TestBackgroundProcess(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	p, err := c.StartBackground(ctx, "echo hi; sleep 0.5; echo bye >&2; exit 3", &net.BackgroundOption{JobDir: srv.Root + "/job"})
+	require.NoError(t, err)
+	assert.Positive(t, p.PID)
+	assert.Equal(t, srv.Root+"/job/output.log", p.LogFile)
+
+	st, err := p.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, net.BackgroundRunning, st.State)
+
+	// pick it up from another connection
+	c2 := newTestClient(t, srv)
+	p2, err := c2.AttachBackground(ctx, p.Dir)
+	require.NoError(t, err)
+	assert.Equal(t, p.PID, p2.PID)
+
+	code, err := p2.Wait(ctx, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 3, code)
+
+	out, offset, err := p2.ReadLog(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "hi\nbye\n", string(out))
+	out, _, err = p2.ReadLog(ctx, offset)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	require.NoError(t, p2.Remove(ctx))
+	_, err = c.AttachBackground(ctx, p.Dir)
+	assert.Error(t, err)
+}
+
+func TestBackgroundProcessKill(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	p, err := c.StartBackground(ctx, "sleep 30 & sleep 30", &net.BackgroundOption{WorkDir: srv.Root})
+	require.NoError(t, err)
+	defer p.Remove(ctx)
+
+	require.NoError(t, p.Kill(ctx, "TERM"))
+	code, err := p.Wait(ctx, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 143, code)
+
+	assert.Error(t, p.Kill(ctx, "BOGUS"))
+}
+
+func TestBackgroundProcessWithoutSetsid(t *testing.T) {
+	// a PATH with the tools StartBackground needs, but no setsid
+	bin := t.TempDir()
+	for _, tool := range []string{"sh", "nohup", "mkdir", "rm", "mv", "cat", "sleep", "mktemp"} {
+		p, err := exec.LookPath(tool)
+		require.NoError(t, err)
+		require.NoError(t, os.Symlink(p, filepath.Join(bin, tool)))
+	}
+	srv := newTestServer(t, sshtest.WithExecHandler(func(req *sshtest.ExecRequest) int {
+		req.Env = append(req.Env, "PATH="+bin)
+		return sshtest.ShellExec(req)
+	}))
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	p, err := c.StartBackground(ctx, "sleep 30", &net.BackgroundOption{JobDir: srv.Root + "/job"})
+	require.NoError(t, err)
+	defer p.Remove(ctx)
+	assert.FileExists(t, srv.Root+"/job/child")
+
+	require.NoError(t, p.Kill(ctx, "TERM"))
+	code, err := p.Wait(ctx, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 143, code)
+}
@@ -0,0 +1,253 @@
+package net
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// DefaultKeepReleases is the number of releases Deploy keeps when DeployOption.Keep is not set.
+const DefaultKeepReleases = 5
+
+// DeployOption controls Deploy. Hooks are shell commands run in the release directory with
+// RELEASE, RELEASE_DIR and DEPLOY_DIR exported.
+type DeployOption struct {
+	// Keep is the number of releases kept, including the new one (DefaultKeepReleases when <= 0).
+	Keep int
+	// PreHooks run after extraction, before current is switched; a failure discards the release.
+	PreHooks []string
+	// PostHooks run after current is switched.
+	PostHooks []string
+	// RollbackOnFailure switches current back to the previous release when a post hook fails.
+	RollbackOnFailure bool
+	// Compress gzips the upload stream.
+	Compress bool
+}
+
+// DeployResult is the outcome of Deploy or Rollback.
+type DeployResult struct {
+	Release  string   // release now pointed to by current
+	Path     string   // its directory
+	Previous string   // release current pointed to before, empty on the first deploy
+	Removed  []string // releases pruned by Deploy
+}
+
+// Release is a directory under <baseDir>/releases.
+type Release struct {
+	Name    string
+	Path    string
+	Current bool
+}
+
+// Deploy uploads localDir to <baseDir>/releases/<UTC timestamp>, runs the pre hooks, atomically
+// points the <baseDir>/current symlink at it, runs the post hooks and prunes old releases.
+// The switch needs mv -T (GNU, busybox), mv -h (BSD, macOS) or python3 on the host. opt may be nil.
+func (c *RichSSHClient) Deploy(ctx context.Context, localDir, baseDir string, opt *DeployOption) (*DeployResult, error) {
+	if opt == nil {
+		opt = &DeployOption{}
+	}
+	keep := opt.Keep
+	if keep <= 0 {
+		keep = DefaultKeepReleases
+	}
+
+	name, err := c.createRelease(ctx, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	res := &DeployResult{Release: name, Path: path.Join(baseDir, "releases", name)}
+	discard := func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		_, _ = c.Run(ctx, "rm -rf "+shellQuote(res.Path))
+	}
+
+	if err = c.UploadDirTar(ctx, localDir, res.Path, opt.Compress); err != nil {
+		discard()
+		return nil, errors.Wrapf(err, "upload release %s failed", name)
+	}
+	for _, hook := range opt.PreHooks {
+		if err = c.runDeployHook(ctx, baseDir, name, hook); err != nil {
+			discard()
+			return nil, errors.Wrap(err, "pre hook failed")
+		}
+	}
+
+	if res.Previous, err = c.currentRelease(ctx, baseDir); err != nil {
+		discard()
+		return nil, err
+	}
+	if err = c.switchRelease(ctx, baseDir, name); err != nil {
+		discard()
+		return nil, err
+	}
+
+	for _, hook := range opt.PostHooks {
+		if err = c.runDeployHook(ctx, baseDir, name, hook); err != nil {
+			err = errors.Wrap(err, "post hook failed")
+			if opt.RollbackOnFailure && res.Previous != "" {
+				if err1 := c.switchRelease(ctx, baseDir, res.Previous); err1 != nil {
+					return res, errors.Wrapf(err, "rollback to %s failed: %v", res.Previous, err1)
+				}
+				res.Release, res.Path = res.Previous, path.Join(baseDir, "releases", res.Previous)
+			}
+			return res, err
+		}
+	}
+
+	res.Removed, err = c.pruneReleases(ctx, baseDir, keep)
+	return res, err
+}
+
+// Releases lists the releases under baseDir, oldest first.
+func (c *RichSSHClient) Releases(ctx context.Context, baseDir string) ([]Release, error) {
+	cmd := fmt.Sprintf("cd %s && { readlink current || true; } && echo @@ && ls -1 releases", shellQuote(baseDir))
+	resp, err := c.Run(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ExitCode != 0 {
+		return nil, fmt.Errorf("list releases failed with exit code %d: %s", resp.ExitCode, strings.TrimSpace(string(resp.Stderr)))
+	}
+
+	head, list, _ := strings.Cut(string(resp.Stdout), "@@\n")
+	current := path.Base(strings.TrimSpace(head))
+	var releases []Release
+	for _, name := range strings.Split(list, "\n") {
+		if name = strings.TrimSpace(name); name == "" || strings.HasPrefix(name, ".") {
+			continue
+		}
+		releases = append(releases, Release{Name: name, Path: path.Join(baseDir, "releases", name), Current: name == current})
+	}
+	sort.Slice(releases, func(i, j int) bool { return releases[i].Name < releases[j].Name })
+	return releases, nil
+}
+
+// Rollback points current at release, or at the release before the current one when release is empty.
+func (c *RichSSHClient) Rollback(ctx context.Context, baseDir, release string) (*DeployResult, error) {
+	releases, err := c.Releases(ctx, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	res := &DeployResult{}
+	target := -1
+	for i, r := range releases {
+		if r.Current {
+			res.Previous = r.Name
+			if release == "" {
+				target = i - 1
+			}
+		}
+		if release != "" && r.Name == release {
+			target = i
+		}
+	}
+	switch {
+	case release != "" && target < 0:
+		return nil, fmt.Errorf("release %s not found in %s", release, baseDir)
+	case release == "" && res.Previous == "":
+		return nil, fmt.Errorf("no current release in %s", baseDir)
+	case target < 0:
+		return nil, fmt.Errorf("no release before %s in %s", res.Previous, baseDir)
+	}
+
+	res.Release, res.Path = releases[target].Name, releases[target].Path
+	if err = c.switchRelease(ctx, baseDir, res.Release); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// createRelease makes a new, empty release directory named after the current UTC time.
+// A numeric suffix keeps names unique (and sorted) for deploys within the same second.
+func (c *RichSSHClient) createRelease(ctx context.Context, baseDir string) (string, error) {
+	name := time.Now().UTC().Format("20060102150405")
+	cmd := fmt.Sprintf(`mkdir -p %[1]s && cd %[1]s && n=%[2]s && i=1 && while ! mkdir "$n" 2>/dev/null; do `+
+		`[ "$i" -lt 100 ] || exit 1; i=$((i+1)); n=$(printf '%%s-%%02d' %[2]s "$i"); done && echo "$n"`, shellQuote(path.Join(baseDir, "releases")), name)
+	resp, err := c.Run(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+	if resp.ExitCode != 0 {
+		return "", fmt.Errorf("create release failed with exit code %d: %s", resp.ExitCode, strings.TrimSpace(string(resp.Stderr)))
+	}
+	return strings.TrimSpace(string(resp.Stdout)), nil
+}
+
+// currentRelease returns the release current points to, or "" when there is none.
+func (c *RichSSHClient) currentRelease(ctx context.Context, baseDir string) (string, error) {
+	resp, err := c.Run(ctx, "readlink "+shellQuote(path.Join(baseDir, "current"))+" || true")
+	if err != nil {
+		return "", err
+	}
+	if s := strings.TrimSpace(string(resp.Stdout)); s != "" {
+		return path.Base(s), nil
+	}
+	return "", nil
+}
+
+// switchRelease replaces the current symlink by renaming a new link over it, so that current
+// always points to a complete release. Renaming over a symlink to a directory needs mv -T (GNU,
+// busybox), mv -h (BSD, macOS) or python3; without any of them the switch fails.
+func (c *RichSSHClient) switchRelease(ctx context.Context, baseDir, name string) error {
+	tmp := fmt.Sprintf(".current.%d", time.Now().UnixNano())
+	cmd := fmt.Sprintf("cd %[1]s && ln -sfn %[2]s %[3]s && { mv -fT %[3]s current 2>/dev/null || mv -fh %[3]s current 2>/dev/null || "+
+		"if command -v python3 >/dev/null 2>&1; then python3 -c %[4]s %[3]s current; "+
+		"else echo 'cannot replace current atomically: mv -T, mv -h or python3 required' >&2; exit 1; fi; }",
+		shellQuote(baseDir), shellQuote(path.Join("releases", name)), tmp, shellQuote("import os, sys; os.replace(sys.argv[1], sys.argv[2])"))
+	resp, err := c.Run(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if resp.ExitCode != 0 {
+		_, _ = c.Run(ctx, "rm -f "+shellQuote(path.Join(baseDir, tmp)))
+		return fmt.Errorf("switch to release %s failed with exit code %d: %s", name, resp.ExitCode, strings.TrimSpace(string(resp.Stderr)))
+	}
+	return nil
+}
+
+// runDeployHook runs hook in the release directory, exporting absolute paths so that hooks
+// changing directory still find them.
+func (c *RichSSHClient) runDeployHook(ctx context.Context, baseDir, name, hook string) error {
+	cmd := fmt.Sprintf(`cd %s && export RELEASE=%s DEPLOY_DIR="$PWD" && export RELEASE_DIR="$DEPLOY_DIR/releases/$RELEASE" && cd "$RELEASE_DIR" && %s`,
+		shellQuote(baseDir), shellQuote(name), hook)
+	resp, err := c.Run(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if resp.ExitCode != 0 {
+		return fmt.Errorf("%s failed with exit code %d: %s", hook, resp.ExitCode, strings.TrimSpace(string(resp.Stderr)))
+	}
+	return nil
+}
+
+// pruneReleases removes the oldest releases beyond keep, never the current one.
+func (c *RichSSHClient) pruneReleases(ctx context.Context, baseDir string, keep int) ([]string, error) {
+	releases, err := c.Releases(ctx, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	var removed, paths []string
+	for i := 0; i < len(releases)-keep; i++ {
+		if !releases[i].Current {
+			removed = append(removed, releases[i].Name)
+			paths = append(paths, shellQuote(releases[i].Path))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	resp, err := c.Run(ctx, "rm -rf "+strings.Join(paths, " "))
+	if err != nil {
+		return nil, err
+	}
+	if resp.ExitCode != 0 {
+		return nil, fmt.Errorf("remove old releases failed with exit code %d: %s", resp.ExitCode, strings.TrimSpace(string(resp.Stderr)))
+	}
+	return removed, nil
+}
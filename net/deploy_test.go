@@ -0,0 +1,118 @@
+package net_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+func TestDeployAndRollback(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	src := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "bin"), 0o755))
+	current := func() string {
+		b, err := os.ReadFile(filepath.Join(srv.Root, "app", "current", "bin", "version"))
+		require.NoError(t, err)
+		return string(b)
+	}
+	deploy := func(version string, opt *net.DeployOption) (*net.DeployResult, error) {
+		require.NoError(t, os.WriteFile(filepath.Join(src, "bin", "version"), []byte(version), 0o644))
+		return c.Deploy(ctx, src, "app", opt)
+	}
+
+	opt := &net.DeployOption{
+		Keep:      2,
+		PreHooks:  []string{`test -f bin/version && echo "$RELEASE" > built`},
+		PostHooks: []string{`echo "$RELEASE" >> "$DEPLOY_DIR/deployed"`},
+	}
+	first, err := deploy("v1", opt)
+	require.NoError(t, err)
+	assert.Empty(t, first.Previous)
+	assert.Equal(t, "v1", current())
+	built, err := os.ReadFile(filepath.Join(srv.Root, first.Path, "built"))
+	require.NoError(t, err)
+	assert.Equal(t, first.Release+"\n", string(built))
+
+	second, err := deploy("v2", opt)
+	require.NoError(t, err)
+	assert.Equal(t, first.Release, second.Previous)
+	assert.Greater(t, second.Release, first.Release)
+	assert.Equal(t, "v2", current())
+
+	third, err := deploy("v3", opt)
+	require.NoError(t, err)
+	assert.Equal(t, []string{first.Release}, third.Removed)
+	assert.NoDirExists(t, filepath.Join(srv.Root, first.Path))
+
+	deployed, err := os.ReadFile(filepath.Join(srv.Root, "app", "deployed"))
+	require.NoError(t, err)
+	assert.Equal(t, first.Release+"\n"+second.Release+"\n"+third.Release+"\n", string(deployed))
+
+	// a failing pre hook leaves current alone and discards the release
+	_, err = deploy("v4", &net.DeployOption{PreHooks: []string{"echo broken >&2; exit 1"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "broken")
+	assert.Equal(t, "v3", current())
+	releases, err := c.Releases(ctx, "app")
+	require.NoError(t, err)
+	require.Len(t, releases, 2)
+	assert.True(t, releases[1].Current)
+
+	res, err := c.Rollback(ctx, "app", "")
+	require.NoError(t, err)
+	assert.Equal(t, second.Release, res.Release)
+	assert.Equal(t, third.Release, res.Previous)
+	assert.Equal(t, "v2", current())
+
+	_, err = c.Rollback(ctx, "app", "")
+	require.Error(t, err)
+	_, err = c.Rollback(ctx, "app", third.Release)
+	require.NoError(t, err)
+	assert.Equal(t, "v3", current())
+
+	// a failing post hook switches back when asked to
+	res, err = deploy("v5", &net.DeployOption{PostHooks: []string{"exit 1"}, RollbackOnFailure: true})
+	require.Error(t, err)
+	assert.Equal(t, third.Release, res.Release)
+	assert.Equal(t, "v3", current())
+}
+
+// fakeMv is an mv without -T and -h, like some minimal systems have.
+const fakeMv = `#!/bin/sh
+case "$1" in -*T*|-*h*) echo "mv: invalid option" >&2; exit 1 ;; esac
+exec "$REAL_MV" "$@"
+`
+
+func TestDeployWithoutMvT(t *testing.T) {
+	realMv, err := exec.LookPath("mv")
+	require.NoError(t, err)
+	t.Setenv("REAL_MV", realMv)
+	srv := newFakeToolsServer(t, map[string]string{"mv": fakeMv})
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	src := t.TempDir()
+	for _, version := range []string{"v1", "v2"} {
+		require.NoError(t, os.WriteFile(filepath.Join(src, "version"), []byte(version), 0o644))
+		_, err = c.Deploy(ctx, src, "app", nil)
+		if _, err1 := exec.LookPath("python3"); err1 != nil {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "python3 required")
+			return
+		}
+		require.NoError(t, err)
+		b, err1 := os.ReadFile(filepath.Join(srv.Root, "app", "current", "version"))
+		require.NoError(t, err1)
+		assert.Equal(t, version, string(b))
+	}
+}
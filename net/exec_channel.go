@@ -0,0 +1,192 @@
+package net
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// exitStatus is how a remote command ended.
+type exitStatus struct {
+	code       int
+	signal     string
+	coreDumped bool
+}
+
+// signalNumbers are the RFC 4254 signal names with their usual Linux numbers.
+var signalNumbers = map[string]int{
+	"HUP": 1, "INT": 2, "QUIT": 3, "ILL": 4, "ABRT": 6, "FPE": 8, "KILL": 9,
+	"USR1": 10, "SEGV": 11, "USR2": 12, "PIPE": 13, "ALRM": 14, "TERM": 15,
+}
+
+// execChannel runs cmd on a raw session channel. ssh.Session is not used because it drops the
+// core-dumped flag of exit-signal and reports signal deaths as errors.
+// stdin may be nil; it is not waited for once the command has exited.
+func execChannel(ctx context.Context, client *ssh.Client, cmd string, pty bool, stdin io.Reader, stdout, stderr io.Writer) (*exitStatus, error) {
+	ch, reqs, err := client.OpenChannel("session", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "open session failed")
+	}
+	defer ch.Close()
+
+	statusCh := make(chan *exitStatus, 1)
+	go func() {
+		st := &exitStatus{code: -1}
+		for req := range reqs {
+			switch req.Type {
+			case "exit-status":
+				if len(req.Payload) >= 4 {
+					st.code = int(binary.BigEndian.Uint32(req.Payload))
+				}
+			case "exit-signal":
+				var sig struct {
+					Signal     string
+					CoreDumped bool
+					Error      string
+					Lang       string
+				}
+				if ssh.Unmarshal(req.Payload, &sig) == nil {
+					st.signal, st.coreDumped = sig.Signal, sig.CoreDumped
+				}
+			default:
+				if req.WantReply {
+					_ = req.Reply(false, nil)
+				}
+			}
+		}
+		statusCh <- st
+	}()
+
+	if pty {
+		ok, err1 := ch.SendRequest("pty-req", true, ssh.Marshal(struct {
+			Term     string
+			Columns  uint32
+			Rows     uint32
+			Width    uint32
+			Height   uint32
+			Modelist string
+		}{Term: "xterm", Columns: 80, Rows: 40, Modelist: encodeTerminalModes(ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		})}))
+		if err1 == nil && !ok {
+			err1 = errors.New("server refused the request")
+		}
+		if err1 != nil {
+			return nil, fmt.Errorf("request pty: %w", err1)
+		}
+	}
+
+	ok, err := ch.SendRequest("exec", true, ssh.Marshal(struct{ Command string }{cmd}))
+	if err == nil && !ok {
+		err = errors.New("server refused the request")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("exec command: %w", err)
+	}
+
+	go func() {
+		if stdin != nil {
+			_, _ = io.Copy(ch, stdin)
+		}
+		_ = ch.CloseWrite()
+	}()
+
+	done := make(chan *exitStatus, 1)
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = io.Copy(stderr, ch.Stderr())
+		}()
+		_, _ = io.Copy(stdout, ch)
+		wg.Wait()
+		done <- <-statusCh
+	}()
+
+	select {
+	case <-ctx.Done():
+		_, _ = ch.SendRequest("signal", false, ssh.Marshal(struct{ Signal string }{string(ssh.SIGKILL)}))
+		return nil, ctx.Err()
+	case st := <-done:
+		if st.code == -1 {
+			if st.signal == "" {
+				return nil, &ssh.ExitMissingError{}
+			}
+			if n, ok := signalNumbers[st.signal]; ok {
+				st.code = 128 + n
+			}
+		}
+		return st, nil
+	}
+}
+
+// encodeTerminalModes serializes modes for a pty-req as RFC 4254 section 8 describes.
+func encodeTerminalModes(modes ssh.TerminalModes) string {
+	b := make([]byte, 0, len(modes)*5+1)
+	for k, v := range modes {
+		b = append(b, k)
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return string(append(b, 0)) // TTY_OP_END
+}
+
+// headTailBuffer captures everything written to it, or only the first and last limit/2 bytes
+// when limit > 0.
+type headTailBuffer struct {
+	limit int
+	head  []byte
+	tail  []byte
+	total int64
+}
+
+func (b *headTailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.total += int64(n)
+	if b.limit <= 0 {
+		b.head = append(b.head, p...)
+		return n, nil
+	}
+
+	if room := b.limit - b.limit/2 - len(b.head); room > 0 {
+		room = min(room, len(p))
+		b.head = append(b.head, p[:room]...)
+		p = p[room:]
+	}
+	if tailCap := b.limit / 2; tailCap > 0 && len(p) > 0 {
+		b.tail = append(b.tail, p...)
+		// trim lazily to keep appends amortized
+		if len(b.tail) > 2*tailCap {
+			b.tail = append(b.tail[:0], b.tail[len(b.tail)-tailCap:]...)
+		}
+	}
+	return n, nil
+}
+
+func (b *headTailBuffer) trimTail() {
+	if tailCap := b.limit / 2; b.limit > 0 && len(b.tail) > tailCap {
+		b.tail = append(b.tail[:0], b.tail[len(b.tail)-tailCap:]...)
+	}
+}
+
+// Bytes returns the head followed by the tail.
+func (b *headTailBuffer) Bytes() []byte {
+	b.trimTail()
+	if len(b.tail) == 0 {
+		return b.head
+	}
+	return append(b.head[:len(b.head):len(b.head)], b.tail...)
+}
+
+func (b *headTailBuffer) truncated() bool {
+	b.trimTail()
+	return b.total > int64(len(b.head)+len(b.tail))
+}
@@ -0,0 +1,339 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// DefaultExpectTimeout is the step timeout used when none is given.
+const DefaultExpectTimeout = 10 * time.Second
+
+// ExpectCase is one alternative of an expect step: when Pattern matches, Send is written to the
+// session and then Callback, if any, is called.
+type ExpectCase struct {
+	Pattern  *regexp.Regexp
+	Send     string
+	Callback func(m *ExpectMatch) error
+}
+
+// Respond is a case answering pattern with send, e.g. Respond(`\[y/N\]\s*$`, "y\n").
+func Respond(pattern, send string) ExpectCase {
+	return ExpectCase{Pattern: regexp.MustCompile(pattern), Send: send}
+}
+
+// OnMatch is a case calling fn when pattern matches.
+func OnMatch(pattern string, fn func(m *ExpectMatch) error) ExpectCase {
+	return ExpectCase{Pattern: regexp.MustCompile(pattern), Callback: fn}
+}
+
+// ExpectStep waits for the first of Cases to match within Timeout (DefaultExpectTimeout if 0).
+type ExpectStep struct {
+	Cases   []ExpectCase
+	Timeout time.Duration
+}
+
+// ExpectMatch is a successful match.
+type ExpectMatch struct {
+	Case   int      // index of the matching case
+	Groups []string // the match and its submatches
+	Before string   // output between the previous match and this one
+}
+
+// ExpectError is returned when no pattern matched before the timeout, EOF or ctx end.
+type ExpectError struct {
+	Patterns   []string
+	LastOutput string // the tail of the output not consumed by earlier matches
+	Err        error  // context.DeadlineExceeded on step timeout, io.EOF when the command ended
+}
+
+func (e *ExpectError) Error() string {
+	return fmt.Sprintf("expect %s: %v; last output: %q", strings.Join(e.Patterns, " | "), e.Err, e.LastOutput)
+}
+
+func (e *ExpectError) Unwrap() error {
+	return e.Err
+}
+
+// expectLastOutputSize is how much output ExpectError.LastOutput keeps.
+const expectLastOutputSize = 1024
+
+// ExpectSession is an interactive command on a pty, driven like expect(1).
+// Terminal echo is off, so sent input does not show up in the output.
+type ExpectSession struct {
+	client *RichSSHClient
+	sess   *ssh.Session
+	stdin  io.WriteCloser
+	ev     *CommandEvent
+
+	mu         sync.Mutex
+	pending    []byte // output not consumed by a match yet
+	transcript bytes.Buffer
+	notify     chan struct{} // closed and replaced on new output or exit
+	exited     bool
+	waitErr    error
+	finishOnce sync.Once
+}
+
+// Spawn starts cmd (a login shell when empty) on a pty for scripted interaction.
+// The session must be closed with Close.
+func (c *RichSSHClient) Spawn(ctx context.Context, cmd string) (*ExpectSession, error) {
+	ev, err := c.before(ctx, c.Host, c.Port, c.User, cmd)
+	if err != nil {
+		return nil, err
+	}
+	e, err := c.spawn(ctx, cmd)
+	if err != nil {
+		ev.ExitCode, ev.Err = -1, err
+		c.after(ctx, ev)
+		return nil, err
+	}
+	e.ev = ev
+	return e, nil
+}
+
+func (c *RichSSHClient) spawn(ctx context.Context, cmd string) (*ExpectSession, error) {
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+	sess, err := c.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          0,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err = sess.RequestPty("xterm", 24, 80, modes); err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("request pty: %w", err)
+	}
+
+	e := &ExpectSession{client: c, sess: sess, notify: make(chan struct{})}
+	sess.Stdout = expectWriter{e}
+	sess.Stderr = expectWriter{e}
+	if e.stdin, err = sess.StdinPipe(); err != nil {
+		sess.Close()
+		return nil, err
+	}
+	if cmd == "" {
+		err = sess.Shell()
+	} else {
+		err = sess.Start(cmd)
+	}
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+
+	go func() {
+		err1 := sess.Wait()
+		e.mu.Lock()
+		e.exited, e.waitErr = true, err1
+		e.broadcast()
+		e.mu.Unlock()
+	}()
+	return e, nil
+}
+
+type expectWriter struct {
+	e *ExpectSession
+}
+
+func (w expectWriter) Write(b []byte) (int, error) {
+	w.e.mu.Lock()
+	defer w.e.mu.Unlock()
+	w.e.pending = append(w.e.pending, b...)
+	w.e.transcript.Write(b)
+	w.e.broadcast()
+	return len(b), nil
+}
+
+// broadcast wakes up waiters; e.mu must be held.
+func (e *ExpectSession) broadcast() {
+	close(e.notify)
+	e.notify = make(chan struct{})
+}
+
+// Expect waits up to timeout (DefaultExpectTimeout if 0) for the earliest match of any case, consumes
+// the output up to the end of the match, sends the case's response and runs its callback.
+func (e *ExpectSession) Expect(ctx context.Context, timeout time.Duration, cases ...ExpectCase) (*ExpectMatch, error) {
+	if len(cases) == 0 {
+		return nil, errors.New("expect needs at least one case")
+	}
+	if timeout <= 0 {
+		timeout = DefaultExpectTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		e.mu.Lock()
+		m, end := matchEarliest(e.pending, cases)
+		if m != nil {
+			e.pending = e.pending[end:]
+			e.mu.Unlock()
+			return m, e.respond(cases[m.Case], m)
+		}
+		exited, notify := e.exited, e.notify
+		e.mu.Unlock()
+
+		if exited {
+			return nil, e.expectError(cases, io.EOF)
+		}
+		select {
+		case <-notify:
+		case <-timer.C:
+			return nil, e.expectError(cases, context.DeadlineExceeded)
+		case <-ctx.Done():
+			return nil, e.expectError(cases, ctx.Err())
+		}
+	}
+}
+
+// matchEarliest returns the match starting first in b (the first case wins ties) and its end offset.
+func matchEarliest(b []byte, cases []ExpectCase) (*ExpectMatch, int) {
+	var (
+		best []int
+		idx  = -1
+	)
+	for i, c := range cases {
+		loc := c.Pattern.FindSubmatchIndex(b)
+		if loc != nil && (best == nil || loc[0] < best[0]) {
+			best, idx = loc, i
+		}
+	}
+	if best == nil {
+		return nil, 0
+	}
+	m := &ExpectMatch{Case: idx, Before: string(b[:best[0]])}
+	for i := 0; i+1 < len(best); i += 2 {
+		if best[i] < 0 {
+			m.Groups = append(m.Groups, "")
+			continue
+		}
+		m.Groups = append(m.Groups, string(b[best[i]:best[i+1]]))
+	}
+	return m, best[1]
+}
+
+func (e *ExpectSession) respond(c ExpectCase, m *ExpectMatch) error {
+	if c.Send != "" {
+		if err := e.Send(c.Send); err != nil {
+			return err
+		}
+	}
+	if c.Callback != nil {
+		return c.Callback(m)
+	}
+	return nil
+}
+
+func (e *ExpectSession) expectError(cases []ExpectCase, err error) *ExpectError {
+	patterns := make([]string, 0, len(cases))
+	for _, c := range cases {
+		patterns = append(patterns, c.Pattern.String())
+	}
+	e.mu.Lock()
+	last := e.pending
+	e.mu.Unlock()
+	if len(last) > expectLastOutputSize {
+		last = last[len(last)-expectLastOutputSize:]
+	}
+	return &ExpectError{Patterns: patterns, LastOutput: string(last), Err: err}
+}
+
+// Run executes steps in order and stops at the first failing one.
+func (e *ExpectSession) Run(ctx context.Context, steps ...ExpectStep) error {
+	for i, step := range steps {
+		if _, err := e.Expect(ctx, step.Timeout, step.Cases...); err != nil {
+			return errors.Wrapf(err, "expect step %d", i+1)
+		}
+	}
+	return nil
+}
+
+// Send writes s to the command's input.
+func (e *ExpectSession) Send(s string) error {
+	_, err := io.WriteString(e.stdin, s)
+	return err
+}
+
+// SendLine writes s followed by a newline.
+func (e *ExpectSession) SendLine(s string) error {
+	return e.Send(s + "\n")
+}
+
+// Transcript returns all output seen so far.
+func (e *ExpectSession) Transcript() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.transcript.String()
+}
+
+// Wait closes the input and waits for the command to exit. It returns an *ssh.ExitError for a
+// non-zero exit status, like ssh.Session.Wait.
+func (e *ExpectSession) Wait(ctx context.Context) error {
+	_ = e.stdin.Close()
+	for {
+		e.mu.Lock()
+		exited, waitErr, notify := e.exited, e.waitErr, e.notify
+		e.mu.Unlock()
+		if exited {
+			e.finish(ctx, waitErr)
+			return waitErr
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			_ = e.sess.Signal(ssh.SIGKILL)
+			e.finish(ctx, ctx.Err())
+			return ctx.Err()
+		}
+	}
+}
+
+// Close ends the session, killing the command if it still runs.
+func (e *ExpectSession) Close() error {
+	e.mu.Lock()
+	exited, waitErr := e.exited, e.waitErr
+	e.mu.Unlock()
+	if !exited {
+		_ = e.sess.Signal(ssh.SIGKILL)
+		waitErr = errors.New("session closed before the command exited")
+	}
+	e.finish(context.Background(), waitErr)
+	err := e.sess.Close()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// finish reports the command to the after hooks once.
+func (e *ExpectSession) finish(ctx context.Context, err error) {
+	e.finishOnce.Do(func() {
+		if e.ev == nil {
+			return
+		}
+		e.mu.Lock()
+		e.ev.StdoutBytes = int64(e.transcript.Len())
+		e.mu.Unlock()
+		e.ev.ExitCode, e.ev.Err = exitCodeOf(err), err
+		var ee *ssh.ExitError
+		if errors.As(err, &ee) {
+			e.ev.Err = nil
+		}
+		e.client.after(ctx, e.ev)
+	})
+}
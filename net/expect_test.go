@@ -0,0 +1,64 @@
+package net_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+func TestExpectSession(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	e, err := c.Spawn(ctx, `printf 'Name? '; read n; printf 'Hello %s\nContinue [y/N] ' "$n"; read a; echo "answer=$a"`)
+	require.NoError(t, err)
+	defer e.Close()
+
+	var greeting string
+	err = e.Run(ctx,
+		net.ExpectStep{Cases: []net.ExpectCase{net.Respond(`Name\? $`, "gopher\n")}},
+		net.ExpectStep{Cases: []net.ExpectCase{
+			net.Respond(`Abort`, "n\n"),
+			net.OnMatch(`Hello (\w+)`, func(m *net.ExpectMatch) error {
+				greeting = m.Groups[1]
+				return nil
+			}),
+		}},
+		net.ExpectStep{Cases: []net.ExpectCase{net.Respond(`\[y/N\] $`, "y\n")}, Timeout: time.Second},
+		net.ExpectStep{Cases: []net.ExpectCase{net.Respond(`answer=y`, "")}},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", greeting)
+	require.NoError(t, e.Wait(ctx))
+	assert.Contains(t, e.Transcript(), "Hello gopher\nContinue [y/N] answer=y\n")
+}
+
+func TestExpectSessionTimeout(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	e, err := c.Spawn(ctx, `echo 'Password:'; sleep 5`)
+	require.NoError(t, err)
+	defer e.Close()
+
+	_, err = e.Expect(ctx, 200*time.Millisecond, net.Respond(`login:`, "root\n"))
+	var ee *net.ExpectError
+	require.ErrorAs(t, err, &ee)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, "Password:\n", ee.LastOutput)
+	assert.Contains(t, err.Error(), `last output: "Password:\n"`)
+
+	e2, err := c.Spawn(ctx, `echo done`)
+	require.NoError(t, err)
+	defer e2.Close()
+	_, err = e2.Expect(ctx, time.Second, net.Respond(`never`, ""))
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,293 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// Facts describes a remote host, as returned by GatherFacts. Sizes are in bytes; fields the host
+// could not report are left zero.
+type Facts struct {
+	Hostname      string
+	Kernel        string // uname -s, e.g. Linux
+	KernelRelease string // uname -r
+	Arch          string // uname -m, e.g. x86_64, aarch64
+	OS            OSRelease
+	CPUs          int
+	MemTotal      uint64
+	MemAvailable  uint64
+	SwapTotal     uint64
+	SwapFree      uint64
+	Mounts        []MountUsage
+	IPs           []string // non-loopback addresses
+	InitSystem    string   // systemd, openrc, runit, sysvinit, busybox, ... or the name of PID 1
+	Uptime        time.Duration
+}
+
+// OSRelease holds the common fields of /etc/os-release.
+type OSRelease struct {
+	ID              string
+	IDLike          []string
+	Name            string
+	PrettyName      string
+	Version         string
+	VersionID       string
+	VersionCodename string
+}
+
+// MountUsage is one line of `df -P -k`.
+type MountUsage struct {
+	Filesystem string
+	MountPoint string
+	Total      uint64
+	Used       uint64
+	Available  uint64
+}
+
+// GoArch maps Arch to the GOARCH naming (amd64, arm64, ...), or returns Arch unchanged.
+func (f *Facts) GoArch() string {
+	switch f.Arch {
+	case "x86_64", "amd64":
+		return "amd64"
+	case "aarch64", "arm64", "armv8l":
+		return "arm64"
+	case "i386", "i486", "i586", "i686", "x86":
+		return "386"
+	case "armv5l", "armv6l", "armv7l", "armhf":
+		return "arm"
+	case "loongarch64":
+		return "loong64"
+	}
+	return f.Arch
+}
+
+// Family returns the OS ID followed by ID_LIKE, e.g. [ubuntu debian], for matching distro families.
+func (o OSRelease) Family() []string {
+	return append([]string{o.ID}, o.IDLike...)
+}
+
+const factsMarker = "@@facts:"
+
+// factsScript prints each section behind a marker line; it only relies on POSIX sh, /proc and
+// commands BusyBox provides, and every probe tolerates failure.
+const factsScript = `echo '` + factsMarker + `uname'; uname -s 2>/dev/null; uname -r 2>/dev/null; uname -m 2>/dev/null
+echo '` + factsMarker + `hostname'; hostname 2>/dev/null || cat /proc/sys/kernel/hostname 2>/dev/null
+echo '` + factsMarker + `os-release'; cat /etc/os-release 2>/dev/null || cat /usr/lib/os-release 2>/dev/null
+echo '` + factsMarker + `cpus'; nproc 2>/dev/null || grep -c '^processor' /proc/cpuinfo 2>/dev/null
+echo '` + factsMarker + `meminfo'; cat /proc/meminfo 2>/dev/null
+echo '` + factsMarker + `df'; df -P -k 2>/dev/null
+echo '` + factsMarker + `ip'; ip -o addr show 2>/dev/null || ifconfig -a 2>/dev/null
+echo '` + factsMarker + `init'; if [ -d /run/systemd/system ]; then echo systemd; elif [ -d /run/openrc ] || command -v openrc >/dev/null 2>&1; then echo openrc; elif [ -d /etc/runit/runsvdir ] || [ -d /run/runit ]; then echo runit; elif [ -L /sbin/init ] && ls -l /sbin/init 2>/dev/null | grep -q busybox; then echo busybox; else cat /proc/1/comm 2>/dev/null; fi
+echo '` + factsMarker + `uptime'; cat /proc/uptime 2>/dev/null
+exit 0`
+
+// GatherFacts collects kernel, distro, architecture, CPU, memory, disk, network, init system and
+// uptime facts of the host in a single command.
+func (c *RichSSHClient) GatherFacts(ctx context.Context) (*Facts, error) {
+	resp, err := c.Run(ctx, factsScript)
+	if err != nil {
+		return nil, errors.Wrap(err, "gather facts failed")
+	}
+	if resp.ExitCode != 0 {
+		return nil, fmt.Errorf("gather facts failed with exit code %d: %s", resp.ExitCode, strings.TrimSpace(string(resp.Stderr)))
+	}
+	return parseFacts(string(resp.Stdout)), nil
+}
+
+func parseFacts(out string) *Facts {
+	sections := make(map[string][]string)
+	var name string
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, factsMarker) {
+			name = strings.TrimPrefix(line, factsMarker)
+			continue
+		}
+		if name != "" && strings.TrimSpace(line) != "" {
+			sections[name] = append(sections[name], line)
+		}
+	}
+
+	f := &Facts{}
+	if u := sections["uname"]; len(u) == 3 {
+		f.Kernel, f.KernelRelease, f.Arch = strings.TrimSpace(u[0]), strings.TrimSpace(u[1]), strings.TrimSpace(u[2])
+	}
+	if h := sections["hostname"]; len(h) > 0 {
+		f.Hostname = strings.TrimSpace(h[0])
+	}
+	f.OS = parseOSRelease(sections["os-release"])
+	if n := sections["cpus"]; len(n) > 0 {
+		f.CPUs, _ = strconv.Atoi(strings.TrimSpace(n[0]))
+	}
+	parseMeminfo(f, sections["meminfo"])
+	f.Mounts = parseDF(sections["df"])
+	f.IPs = parseIPs(sections["ip"])
+	if i := sections["init"]; len(i) > 0 {
+		f.InitSystem = strings.TrimSpace(i[0])
+		if f.InitSystem == "init" {
+			f.InitSystem = "sysvinit"
+		}
+	}
+	if u := sections["uptime"]; len(u) > 0 {
+		if fields := strings.Fields(u[0]); len(fields) > 0 {
+			if secs, err := strconv.ParseFloat(fields[0], 64); err == nil {
+				f.Uptime = time.Duration(secs * float64(time.Second))
+			}
+		}
+	}
+	return f
+}
+
+func parseOSRelease(lines []string) OSRelease {
+	var o OSRelease
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = unquoteOSReleaseValue(value)
+		switch key {
+		case "ID":
+			o.ID = value
+		case "ID_LIKE":
+			o.IDLike = strings.Fields(value)
+		case "NAME":
+			o.Name = value
+		case "PRETTY_NAME":
+			o.PrettyName = value
+		case "VERSION":
+			o.Version = value
+		case "VERSION_ID":
+			o.VersionID = value
+		case "VERSION_CODENAME":
+			o.VersionCodename = value
+		}
+	}
+	return o
+}
+
+// unquoteOSReleaseValue strips shell-style quotes and backslash escapes as os-release(5) allows.
+func unquoteOSReleaseValue(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		quote := v[0]
+		v = v[1 : len(v)-1]
+		if quote == '\'' {
+			return v
+		}
+	}
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+1 < len(v) {
+			i++
+		}
+		sb.WriteByte(v[i])
+	}
+	return sb.String()
+}
+
+func parseMeminfo(f *Facts, lines []string) {
+	values := make(map[string]uint64)
+	for _, line := range lines {
+		key, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 1 && strings.EqualFold(fields[1], "kB") {
+			n *= 1024
+		}
+		values[key] = n
+	}
+	f.MemTotal = values["MemTotal"]
+	f.SwapTotal = values["SwapTotal"]
+	f.SwapFree = values["SwapFree"]
+	if v, ok := values["MemAvailable"]; ok {
+		f.MemAvailable = v
+	} else {
+		// kernels before 3.14 have no MemAvailable
+		f.MemAvailable = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+}
+
+// parseDF parses POSIX df output in 1K blocks; filesystem names containing spaces are kept whole.
+func parseDF(lines []string) []MountUsage {
+	var mounts []MountUsage
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if i == 0 && len(fields) > 0 && fields[0] == "Filesystem" {
+			continue
+		}
+		if len(fields) < 6 {
+			continue
+		}
+		n := len(fields)
+		total, err1 := strconv.ParseUint(fields[n-5], 10, 64)
+		used, err2 := strconv.ParseUint(fields[n-4], 10, 64)
+		avail, err3 := strconv.ParseUint(fields[n-3], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil || total == 0 {
+			continue
+		}
+		mounts = append(mounts, MountUsage{
+			Filesystem: strings.Join(fields[:n-5], " "),
+			MountPoint: fields[n-1],
+			Total:      total * 1024,
+			Used:       used * 1024,
+			Available:  avail * 1024,
+		})
+	}
+	return mounts
+}
+
+// parseIPs extracts addresses from `ip -o addr` or (BusyBox/net-tools) `ifconfig` output.
+func parseIPs(lines []string) []string {
+	var ips []string
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			var candidate string
+			switch {
+			case (field == "inet" || field == "inet6") && i+1 < len(fields):
+				candidate = fields[i+1]
+			case strings.HasPrefix(field, "addr:"):
+				// BusyBox ifconfig: "inet addr:10.0.0.5" and "inet6 addr: fe80::1/64"
+				candidate = strings.TrimPrefix(field, "addr:")
+				if candidate == "" && i+1 < len(fields) {
+					candidate = fields[i+1]
+				}
+			default:
+				continue
+			}
+			candidate, _, _ = strings.Cut(candidate, "/")
+			candidate, _, _ = strings.Cut(candidate, "%")
+			ip := net.ParseIP(candidate)
+			if ip == nil || ip.IsLoopback() || seen[ip.String()] {
+				continue
+			}
+			seen[ip.String()] = true
+			ips = append(ips, ip.String())
+		}
+	}
+	return ips
+}
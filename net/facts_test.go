@@ -0,0 +1,88 @@
+package net_test
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+	"github.com/designinlife/slib/net/sshtest"
+)
+
+const busyboxFacts = `@@facts:uname
+Linux
+5.10.0
+armv7l
+@@facts:hostname
+router
+@@facts:os-release
+NAME="Alpine Linux"
+ID=alpine
+VERSION_ID=3.19.1
+PRETTY_NAME="Alpine Linux v3.19"
+@@facts:cpus
+4
+@@facts:meminfo
+MemTotal:         507904 kB
+MemFree:          100000 kB
+Buffers:           10000 kB
+Cached:            90000 kB
+SwapTotal:             0 kB
+@@facts:df
+Filesystem           1024-blocks    Used Available Capacity Mounted on
+/dev/root                 1000       400       600  40% /
+tmpfs                        0         0         0   0% /dev/shm
+@@facts:ip
+eth0      Link encap:Ethernet  HWaddr 00:11:22:33:44:55
+          inet addr:192.168.1.1  Bcast:192.168.1.255  Mask:255.255.255.0
+          inet6 addr: fe80::211:22ff:fe33:4455/64 Scope:Link
+lo        Link encap:Local Loopback
+          inet addr:127.0.0.1  Mask:255.0.0.0
+@@facts:init
+busybox
+@@facts:uptime
+3600.50 7000.00
+`
+
+func TestGatherFactsBusyBox(t *testing.T) {
+	srv := newTestServer(t, sshtest.WithExecHandler(func(req *sshtest.ExecRequest) int {
+		_, _ = io.WriteString(req.Stdout, busyboxFacts)
+		return 0
+	}))
+	c := newTestClient(t, srv)
+
+	f, err := c.GatherFacts(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "router", f.Hostname)
+	assert.Equal(t, "arm", f.GoArch())
+	assert.Equal(t, "alpine", f.OS.ID)
+	assert.Equal(t, "Alpine Linux v3.19", f.OS.PrettyName)
+	assert.Equal(t, 4, f.CPUs)
+	assert.Equal(t, uint64(507904*1024), f.MemTotal)
+	assert.Equal(t, uint64(200000*1024), f.MemAvailable)
+	assert.Equal(t, []net.MountUsage{{Filesystem: "/dev/root", MountPoint: "/", Total: 1000 * 1024, Used: 400 * 1024, Available: 600 * 1024}}, f.Mounts)
+	assert.Equal(t, []string{"192.168.1.1", "fe80::211:22ff:fe33:4455"}, f.IPs)
+	assert.Equal(t, "busybox", f.InitSystem)
+	assert.Equal(t, 3600500*time.Millisecond, f.Uptime)
+}
+
+func TestGatherFactsLocal(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs a Linux host")
+	}
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+
+	f, err := c.GatherFacts(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "Linux", f.Kernel)
+	assert.Equal(t, runtime.GOARCH, f.GoArch())
+	assert.Positive(t, f.CPUs)
+	assert.Positive(t, f.MemTotal)
+	assert.Positive(t, f.Uptime)
+}
@@ -0,0 +1,356 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// FileEditOption controls the idempotent file operations.
+type FileEditOption struct {
+	// Backup keeps the previous version as "<path>.<timestamp>~" when the file changes.
+	Backup bool
+	// Mode is used for files that do not exist yet (default 0644); existing files keep their mode.
+	Mode os.FileMode
+	// MarkerPrefix starts the EnsureBlock marker lines (default "#").
+	MarkerPrefix string
+	// DryRun computes Changed and Diff without writing anything.
+	DryRun bool
+}
+
+// FileEditResult is the outcome of a file operation.
+type FileEditResult struct {
+	Path       string
+	Changed    bool
+	Diff       string // unified diff of the change, empty when unchanged
+	BackupPath string // set when a backup was written
+}
+
+// EnsureLine makes sure path contains line. When regex is not empty, the last line matching it is
+// replaced by line, and line is appended only if no line matches; otherwise line is appended when it
+// is not present verbatim. A missing file is created. opt may be nil.
+func (c *RichSSHClient) EnsureLine(ctx context.Context, remotePath, regex, line string, opt *FileEditOption) (*FileEditResult, error) {
+	re, err := compileLineRegex(regex, line)
+	if err != nil {
+		return nil, err
+	}
+	return c.EditFile(ctx, remotePath, opt, func(old []byte) ([]byte, error) {
+		lines := splitLines(old)
+		last := -1
+		for i, l := range lines {
+			if re.MatchString(l) {
+				last = i
+			}
+		}
+		if last >= 0 {
+			lines[last] = line
+		} else {
+			lines = append(lines, line)
+		}
+		return joinLines(lines), nil
+	})
+}
+
+// EnsureLineAbsent removes every line of path matching regex. A missing file is left alone.
+func (c *RichSSHClient) EnsureLineAbsent(ctx context.Context, remotePath, regex string, opt *FileEditOption) (*FileEditResult, error) {
+	re, err := compileLineRegex(regex, "")
+	if err != nil {
+		return nil, err
+	}
+	return c.editFile(ctx, remotePath, opt, false, func(old []byte) ([]byte, error) {
+		var kept []string
+		for _, l := range splitLines(old) {
+			if !re.MatchString(l) {
+				kept = append(kept, l)
+			}
+		}
+		return joinLines(kept), nil
+	})
+}
+
+// EnsureBlock keeps block between the lines "<prefix> BEGIN <name>" and "<prefix> END <name>" of
+// path, appending the marked block when it is missing. An empty block removes the markers too.
+func (c *RichSSHClient) EnsureBlock(ctx context.Context, remotePath, name, block string, opt *FileEditOption) (*FileEditResult, error) {
+	prefix := "#"
+	if opt != nil && opt.MarkerPrefix != "" {
+		prefix = opt.MarkerPrefix
+	}
+	begin, end := prefix+" BEGIN "+name, prefix+" END "+name
+	return c.editFile(ctx, remotePath, opt, block != "", func(old []byte) ([]byte, error) {
+		lines := splitLines(old)
+		start, stop := -1, -1
+		for i, l := range lines {
+			if l == begin && start < 0 {
+				start = i
+			} else if l == end && start >= 0 {
+				stop = i
+				break
+			}
+		}
+		if start >= 0 && stop < 0 {
+			return nil, fmt.Errorf("%s has %q without %q", remotePath, begin, end)
+		}
+
+		var replacement []string
+		if block != "" {
+			replacement = append([]string{begin}, splitLines([]byte(block))...)
+			replacement = append(replacement, end)
+		}
+		if start < 0 {
+			return joinLines(append(lines, replacement...)), nil
+		}
+		out := append(append(append([]string{}, lines[:start]...), replacement...), lines[stop+1:]...)
+		return joinLines(out), nil
+	})
+}
+
+// RenderTemplate executes the text/template tmpl with data and writes the result to path when it
+// differs from the current content.
+func (c *RichSSHClient) RenderTemplate(ctx context.Context, remotePath, tmpl string, data any, opt *FileEditOption) (*FileEditResult, error) {
+	t, err := template.New(path.Base(remotePath)).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse template failed")
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, data); err != nil {
+		return nil, errors.Wrap(err, "render template failed")
+	}
+	return c.WriteFileIfChanged(ctx, remotePath, buf.Bytes(), opt)
+}
+
+// WriteFileIfChanged writes content to path unless the file already holds exactly that content.
+func (c *RichSSHClient) WriteFileIfChanged(ctx context.Context, remotePath string, content []byte, opt *FileEditOption) (*FileEditResult, error) {
+	return c.EditFile(ctx, remotePath, opt, func([]byte) ([]byte, error) {
+		return content, nil
+	})
+}
+
+// EditFile applies edit to the content of path (empty when the file does not exist) and writes the
+// result back atomically over SFTP when it differs, keeping the mode and, where permitted, the owner.
+// A symbolic link is followed and its target is written, leaving the link in place.
+func (c *RichSSHClient) EditFile(ctx context.Context, remotePath string, opt *FileEditOption, edit func(old []byte) ([]byte, error)) (*FileEditResult, error) {
+	return c.editFile(ctx, remotePath, opt, true, edit)
+}
+
+func (c *RichSSHClient) editFile(ctx context.Context, remotePath string, opt *FileEditOption, create bool, edit func(old []byte) ([]byte, error)) (*FileEditResult, error) {
+	if opt == nil {
+		opt = &FileEditOption{}
+	}
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+	if err := c.ensureSFTP(); err != nil {
+		return nil, errors.Wrap(err, "sftp unavailable")
+	}
+
+	res := &FileEditResult{Path: remotePath}
+	remotePath, err := c.resolveSymlinks(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	old, fi, err := c.readRemoteFile(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if fi == nil && !create {
+		return res, nil
+	}
+	content, err := edit(old)
+	if err != nil {
+		return nil, err
+	}
+	if fi != nil && bytes.Equal(old, content) {
+		return res, nil
+	}
+
+	res.Changed = true
+	res.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(old)),
+		B:        difflib.SplitLines(string(content)),
+		FromFile: remotePath,
+		ToFile:   remotePath,
+		Context:  3,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opt.DryRun {
+		return res, nil
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mode := opt.Mode
+	if mode == 0 {
+		mode = 0o644
+	}
+	if fi != nil {
+		mode = fi.Mode().Perm()
+		if opt.Backup {
+			res.BackupPath = fmt.Sprintf("%s.%s~", remotePath, time.Now().Format("2006-01-02@15:04:05"))
+			if err = c.writeRemoteFileAtomic(res.BackupPath, old, mode, fi); err != nil {
+				return nil, errors.Wrap(err, "write backup failed")
+			}
+		}
+	}
+	if err = c.writeRemoteFileAtomic(remotePath, content, mode, fi); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// readRemoteFile returns the content and info of a file, or nil info when it does not exist.
+func (c *RichSSHClient) readRemoteFile(remotePath string) ([]byte, os.FileInfo, error) {
+	f, err := c.sftpClient.Open(remotePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "open %s failed", remotePath)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, nil, fmt.Errorf("%s is not a regular file", remotePath)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "read %s failed", remotePath)
+	}
+	return b, fi, nil
+}
+
+// writeRemoteFileAtomic writes a temp file next to remotePath and renames it into place.
+// The owner of like is copied when the server allows it.
+func (c *RichSSHClient) writeRemoteFileAtomic(remotePath string, content []byte, mode os.FileMode, like os.FileInfo) error {
+	tmp := path.Join(path.Dir(remotePath), fmt.Sprintf(".%s.%d.tmp", path.Base(remotePath), time.Now().UnixNano()))
+	f, err := c.sftpClient.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return errors.Wrapf(err, "create %s failed", tmp)
+	}
+	_, err = f.Write(content)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = c.sftpClient.Chmod(tmp, mode)
+	}
+	if err != nil {
+		_ = c.sftpClient.Remove(tmp)
+		return errors.Wrapf(err, "write %s failed", tmp)
+	}
+	if like != nil {
+		if st, ok := like.Sys().(*sftp.FileStat); ok {
+			_ = c.sftpClient.Chown(tmp, int(st.UID), int(st.GID))
+		}
+	}
+
+	if _, ok := c.sftpClient.HasExtension("posix-rename@openssh.com"); ok {
+		err = c.sftpClient.PosixRename(tmp, remotePath)
+	} else {
+		err = c.renameReplace(tmp, remotePath)
+	}
+	if err != nil {
+		_ = c.sftpClient.Remove(tmp)
+		return errors.Wrapf(err, "rename %s to %s failed", tmp, remotePath)
+	}
+	return nil
+}
+
+// renameReplace replaces remotePath with tmp on servers whose rename refuses to overwrite. The old
+// file is moved aside first and only removed once tmp is in place, or moved back on failure.
+func (c *RichSSHClient) renameReplace(tmp, remotePath string) error {
+	err := c.sftpClient.Rename(tmp, remotePath)
+	if err == nil {
+		return nil
+	}
+	if _, err1 := c.sftpClient.Lstat(remotePath); err1 != nil {
+		return err
+	}
+	old := tmp + ".old"
+	if err = c.sftpClient.Rename(remotePath, old); err != nil {
+		return err
+	}
+	if err = c.sftpClient.Rename(tmp, remotePath); err != nil {
+		if err1 := c.sftpClient.Rename(old, remotePath); err1 != nil {
+			return fmt.Errorf("%v; the previous file was kept as %s", err, old)
+		}
+		return err
+	}
+	_ = c.sftpClient.Remove(old)
+	return nil
+}
+
+// resolveSymlinks follows remotePath through symbolic links, so that edits replace the target
+// rather than the link. The final target does not need to exist.
+func (c *RichSSHClient) resolveSymlinks(remotePath string) (string, error) {
+	for i := 0; i < 40; i++ {
+		fi, err := c.sftpClient.Lstat(remotePath)
+		if errors.Is(err, os.ErrNotExist) {
+			return remotePath, nil
+		}
+		if err != nil {
+			return "", errors.Wrapf(err, "stat %s failed", remotePath)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return remotePath, nil
+		}
+		target, err := c.sftpClient.ReadLink(remotePath)
+		if err != nil {
+			return "", errors.Wrapf(err, "read link %s failed", remotePath)
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(remotePath), target)
+		}
+		remotePath = target
+	}
+	return "", fmt.Errorf("too many levels of symbolic links: %s", remotePath)
+}
+
+// compileLineRegex compiles regex, or an exact match of line when regex is empty.
+func compileLineRegex(regex, line string) (*regexp.Regexp, error) {
+	if regex == "" {
+		if line == "" {
+			return nil, errors.New("regex or line is required")
+		}
+		regex = "^" + regexp.QuoteMeta(line) + "$"
+	}
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid regex %s", regex)
+	}
+	return re, nil
+}
+
+// splitLines splits content into lines without their "\n".
+func splitLines(b []byte) []string {
+	s := strings.TrimSuffix(string(b), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// joinLines joins lines with a trailing newline.
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
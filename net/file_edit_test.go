@@ -0,0 +1,106 @@
+package net_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+func TestFileEdit(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	name := filepath.Join(srv.Root, "sshd_config")
+	require.NoError(t, os.WriteFile(name, []byte("Port 22\n#PermitRootLogin yes\nPasswordAuthentication yes\n"), 0o600))
+	read := func() string {
+		b, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	res, err := c.EnsureLine(ctx, "sshd_config", `^#?PermitRootLogin\b`, "PermitRootLogin no", &net.FileEditOption{Backup: true})
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	assert.Contains(t, res.Diff, "-#PermitRootLogin yes\n+PermitRootLogin no\n")
+	assert.Equal(t, "Port 22\nPermitRootLogin no\nPasswordAuthentication yes\n", read())
+	backup, err := os.ReadFile(filepath.Join(srv.Root, res.BackupPath))
+	require.NoError(t, err)
+	assert.Equal(t, "Port 22\n#PermitRootLogin yes\nPasswordAuthentication yes\n", string(backup))
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	res, err = c.EnsureLine(ctx, "sshd_config", `^#?PermitRootLogin\b`, "PermitRootLogin no", nil)
+	require.NoError(t, err)
+	assert.False(t, res.Changed)
+	assert.Empty(t, res.Diff)
+
+	res, err = c.EnsureLine(ctx, "sshd_config", "", "UseDNS no", &net.FileEditOption{DryRun: true})
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	assert.NotContains(t, read(), "UseDNS")
+
+	res, err = c.EnsureLineAbsent(ctx, "sshd_config", `^PasswordAuthentication`, nil)
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	assert.Equal(t, "Port 22\nPermitRootLogin no\n", read())
+
+	res, err = c.EnsureBlock(ctx, "sshd_config", "deploy", "Match User deploy\n  PasswordAuthentication no", nil)
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	res, err = c.EnsureBlock(ctx, "sshd_config", "deploy", "Match User ci", nil)
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	assert.Equal(t, "Port 22\nPermitRootLogin no\n# BEGIN deploy\nMatch User ci\n# END deploy\n", read())
+	res, err = c.EnsureBlock(ctx, "sshd_config", "deploy", "", nil)
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	assert.Equal(t, "Port 22\nPermitRootLogin no\n", read())
+
+	tmpl := "listen {{.Port}};\n"
+	res, err = c.RenderTemplate(ctx, "conf/site.conf", tmpl, map[string]int{"Port": 8080}, &net.FileEditOption{Mode: 0o640})
+	assert.Error(t, err, "parent directory does not exist")
+	require.NoError(t, os.Mkdir(filepath.Join(srv.Root, "conf"), 0o755))
+	res, err = c.RenderTemplate(ctx, "conf/site.conf", tmpl, map[string]int{"Port": 8080}, &net.FileEditOption{Mode: 0o640})
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	fi, err = os.Stat(filepath.Join(srv.Root, "conf/site.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), fi.Mode().Perm())
+	res, err = c.RenderTemplate(ctx, "conf/site.conf", tmpl, map[string]int{"Port": 8080}, nil)
+	require.NoError(t, err)
+	assert.False(t, res.Changed)
+	_, err = c.RenderTemplate(ctx, "conf/site.conf", tmpl, map[string]int{}, nil)
+	assert.Error(t, err)
+}
+
+func TestFileEditSymlink(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	require.NoError(t, os.Mkdir(filepath.Join(srv.Root, "run"), 0o755))
+	target := filepath.Join(srv.Root, "run", "resolv.conf")
+	require.NoError(t, os.WriteFile(target, []byte("nameserver 10.0.0.1\n"), 0o644))
+	link := filepath.Join(srv.Root, "resolv.conf")
+	require.NoError(t, os.Symlink("run/resolv.conf", link))
+
+	res, err := c.EnsureLine(ctx, "resolv.conf", "", "options edns0", &net.FileEditOption{Backup: true})
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+
+	fi, err := os.Lstat(link)
+	require.NoError(t, err)
+	assert.NotZero(t, fi.Mode()&os.ModeSymlink, "the link must stay a link")
+	b, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "nameserver 10.0.0.1\noptions edns0\n", string(b))
+	assert.Contains(t, res.BackupPath, "run/resolv.conf.")
+}
@@ -0,0 +1,315 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/pkg/sftp"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// LogLine is one line of a followed remote file, without the line ending.
+type LogLine struct {
+	Host string
+	File string
+	Line string
+	Time time.Time // when the line was received
+}
+
+// FollowOption controls Follow.
+type FollowOption struct {
+	// Lines is the number of existing lines to deliver first; 0 starts at the end of each file.
+	Lines int
+	// UseSFTP polls the files over SFTP instead of running `tail -F`. It is used automatically
+	// when the server does not allow exec or has no tail.
+	UseSFTP bool
+	// PollInterval is the SFTP polling interval (default 1s).
+	PollInterval time.Duration
+}
+
+// Follow streams new lines of the remote files to fn until ctx is done, across log rotation and
+// truncation, like `tail -F`. fn is never called concurrently. opt may be nil.
+// It returns nil once ctx is cancelled, or the first error of any file.
+func (c *RichSSHClient) Follow(ctx context.Context, files []string, opt *FollowOption, fn func(line LogLine)) error {
+	if len(files) == 0 {
+		return errors.New("no files to follow")
+	}
+	if opt == nil {
+		opt = &FollowOption{}
+	}
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	deliver := func(file, line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ctx.Err() == nil {
+			fn(LogLine{Host: c.Host, File: file, Line: line, Time: time.Now()})
+		}
+	}
+	for _, file := range files {
+		wg.Add(1)
+		go func(file string) {
+			defer wg.Done()
+			if err := c.followFile(ctx, file, opt, deliver); err != nil && ctx.Err() == nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = errors.Wrapf(err, "follow %s:%s failed", c.Host, file)
+				}
+				mu.Unlock()
+				cancel()
+			}
+		}(file)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// FollowChan is Follow delivering lines on a channel. The lines channel is closed when following
+// stops; the error channel then receives Follow's result.
+func (c *RichSSHClient) FollowChan(ctx context.Context, files []string, opt *FollowOption) (<-chan LogLine, <-chan error) {
+	lines := make(chan LogLine, 64)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		err := c.Follow(ctx, files, opt, func(line LogLine) {
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+			}
+		})
+		close(lines)
+		errCh <- err
+	}()
+	return lines, errCh
+}
+
+func (c *RichSSHClient) followFile(ctx context.Context, file string, opt *FollowOption, deliver func(file, line string)) error {
+	if !opt.UseSFTP {
+		w := &lineSplitter{emit: func(line string) { deliver(file, line) }}
+		cmd := fmt.Sprintf("tail -n %d -F -- %s", max(opt.Lines, 0), shellQuote(file))
+		err := c.RunStream(ctx, cmd, w, io.Discard)
+		if ctx.Err() != nil {
+			return nil
+		}
+		// only fall back when tail never ran: polling starts over and would deliver the
+		// initial lines again
+		var ee *ssh.ExitError
+		if !errors.Is(err, errExecRefused) && !(errors.As(err, &ee) && ee.ExitStatus() == 127) {
+			return err
+		}
+	}
+	if err := c.ensureSFTP(); err != nil {
+		return errors.Wrap(err, "sftp unavailable")
+	}
+	return c.pollFile(ctx, file, opt, deliver)
+}
+
+// pollFile follows file over SFTP. The path is taken to be a new file (rotation) when it is shorter
+// than what was read, or when its attributes differ from the open handle and either its inode
+// changed (when stat can be run) or, without inodes, the difference persists over two polls while
+// the handle stays unchanged. The old file is drained before the new one is opened from the start.
+func (c *RichSSHClient) pollFile(ctx context.Context, file string, opt *FollowOption, deliver func(file, line string)) error {
+	interval := opt.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	w := &lineSplitter{emit: func(line string) { deliver(file, line) }}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		f       *sftp.File
+		offset  int64
+		first   = true
+		inode   string      // of the open file, empty when unknown
+		suspect os.FileInfo // handle attributes at the last poll where the path differed
+	)
+	defer func() {
+		if f != nil {
+			_ = f.Close()
+		}
+	}()
+	for {
+		if f == nil {
+			nf, err := c.sftpClient.Open(file)
+			switch {
+			case err == nil:
+				f = nf
+				offset = 0
+				inode, suspect = c.fileInode(ctx, file), nil
+				if first {
+					if offset, err = initialOffset(f, opt.Lines); err != nil {
+						return err
+					}
+				}
+			case !errors.Is(err, os.ErrNotExist):
+				return err
+			}
+			first = false
+		}
+
+		if f != nil {
+			// drain the open file, which may already have been rotated away
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			// plain reads: File.WriteTo reads ahead concurrently and can skip data of a
+			// file that grows meanwhile
+			n, err := io.Copy(w, struct{ io.Reader }{f})
+			offset += n
+			if err != nil {
+				return err
+			}
+
+			handleInfo, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			pathInfo, err := c.sftpClient.Stat(file)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				// removed; keep the handle until the file is recreated
+			case err != nil:
+				return err
+			case handleInfo.Size() < offset:
+				// truncated in place
+				w.flush()
+				offset = 0
+			default:
+				var rotated bool
+				switch {
+				case pathInfo.Size() < offset:
+					rotated = true
+				case sameFileStat(handleInfo, pathInfo):
+					suspect = nil
+				case inode != "":
+					// a file being written can differ between the two stats, so ask for its inode
+					cur := c.fileInode(ctx, file)
+					rotated = cur != "" && cur != inode
+				default:
+					// a file being written differs only briefly, and its handle grows meanwhile
+					rotated = suspect != nil && sameFileStat(suspect, handleInfo)
+					suspect = handleInfo
+				}
+				if rotated {
+					// reopen the path from the start
+					w.flush()
+					_ = f.Close()
+					f = nil
+					continue
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// fileInode returns the inode of file (following symlinks), or "" when stat cannot be run.
+func (c *RichSSHClient) fileInode(ctx context.Context, file string) string {
+	q := shellQuote(file)
+	resp, err := c.Run(ctx, fmt.Sprintf("stat -L -c %%i -- %s 2>/dev/null || stat -L -f %%i -- %s", q, q))
+	if err != nil || resp.ExitCode != 0 {
+		return ""
+	}
+	return strings.TrimSpace(string(resp.Stdout))
+}
+
+// sameFileStat reports whether a and b have the same size, mtime, mode and owner.
+func sameFileStat(a, b os.FileInfo) bool {
+	if a.Size() != b.Size() || !a.ModTime().Equal(b.ModTime()) || a.Mode() != b.Mode() {
+		return false
+	}
+	sa, ok1 := a.Sys().(*sftp.FileStat)
+	sb, ok2 := b.Sys().(*sftp.FileStat)
+	return !ok1 || !ok2 || (sa.UID == sb.UID && sa.GID == sb.GID)
+}
+
+// initialOffset returns where to start reading f to deliver its last n lines.
+func initialOffset(f *sftp.File, n int) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := fi.Size()
+	if n <= 0 || size == 0 {
+		return size, nil
+	}
+
+	const chunk = 8 * 1024
+	var (
+		pos   = size
+		found = 0
+		buf   = make([]byte, chunk)
+	)
+	for pos > 0 {
+		readSize := min(int64(chunk), pos)
+		pos -= readSize
+		if _, err = f.ReadAt(buf[:readSize], pos); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := readSize - 1; i >= 0; i-- {
+			if pos+i == size-1 {
+				// a trailing newline ends the last line rather than starting a new one
+				continue
+			}
+			if buf[i] == '\n' {
+				found++
+				if found == n {
+					return pos + i + 1, nil
+				}
+			}
+		}
+	}
+	return 0, nil
+}
+
+// lineSplitter calls emit for each complete line written to it, without "\n" or "\r\n".
+type lineSplitter struct {
+	buf  []byte
+	emit func(line string)
+}
+
+func (w *lineSplitter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// flush emits a pending partial line.
+func (w *lineSplitter) flush() {
+	if len(w.buf) > 0 {
+		w.emit(strings.TrimSuffix(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
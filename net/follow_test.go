@@ -0,0 +1,165 @@
+package net_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+	"github.com/designinlife/slib/net/sshtest"
+)
+
+func TestFollow(t *testing.T) {
+	noTail := sshtest.WithExecHandler(func(*sshtest.ExecRequest) int { return 127 })
+	for _, tc := range []struct {
+		name    string
+		useSFTP bool
+		opts    []sshtest.Option
+	}{
+		{name: "tail"},
+		{name: "sftp", useSFTP: true},
+		{name: "fallback", opts: []sshtest.Option{noTail}},
+		{name: "no exec", opts: []sshtest.Option{sshtest.WithoutExec()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, tc.opts...)
+			c := newTestClient(t, srv)
+			logFile := filepath.Join(srv.Root, "app.log")
+			require.NoError(t, os.WriteFile(logFile, []byte("old1\nold2\nold3\n"), 0o644))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			lines, errCh := c.FollowChan(ctx, []string{"app.log"}, &net.FollowOption{Lines: 2, UseSFTP: tc.useSFTP, PollInterval: 50 * time.Millisecond})
+
+			next := func() net.LogLine {
+				select {
+				case l := <-lines:
+					return l
+				case <-ctx.Done():
+					t.Fatal("timed out waiting for a line")
+					return net.LogLine{}
+				}
+			}
+			assert.Equal(t, net.LogLine{Host: srv.Host, File: "app.log", Line: "old2"}, withoutTime(next()))
+			assert.Equal(t, "old3", next().Line)
+
+			appendFile(t, logFile, "new1\n")
+			assert.Equal(t, "new1", next().Line)
+
+			// rotate
+			time.Sleep(300 * time.Millisecond)
+			require.NoError(t, os.Rename(logFile, logFile+".1"))
+			require.NoError(t, os.WriteFile(logFile, []byte("rotated\n"), 0o644))
+			assert.Equal(t, "rotated", next().Line)
+
+			// truncate
+			time.Sleep(300 * time.Millisecond)
+			require.NoError(t, os.WriteFile(logFile, nil, 0o644))
+			time.Sleep(300 * time.Millisecond)
+			appendFile(t, logFile, "after truncate\n")
+			assert.Equal(t, "after truncate", next().Line)
+
+			cancel()
+			for range lines {
+			}
+			assert.NoError(t, <-errCh)
+		})
+	}
+}
+
+func TestFollowStreamError(t *testing.T) {
+	// tail delivers the initial lines, then the channel closes without an exit status
+	srv := newTestServer(t, sshtest.WithExecHandler(func(req *sshtest.ExecRequest) int {
+		_, _ = io.WriteString(req.Stdout, "old1\nold2\n")
+		_ = req.Stdin.(io.Closer).Close()
+		return 0
+	}))
+	c := newTestClient(t, srv)
+	require.NoError(t, os.WriteFile(filepath.Join(srv.Root, "app.log"), []byte("old1\nold2\n"), 0o644))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var got []string
+	err := c.Follow(ctx, []string{"app.log"}, &net.FollowOption{Lines: 2, PollInterval: 50 * time.Millisecond}, func(line net.LogLine) {
+		got = append(got, line.Line)
+	})
+	require.Error(t, err, "no fallback to polling")
+	assert.Equal(t, []string{"old1", "old2"}, got)
+}
+
+func TestFollowSFTPGrowingFile(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []sshtest.Option
+	}{
+		{name: "inode"},
+		{name: "no exec", opts: []sshtest.Option{sshtest.WithoutExec()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, tc.opts...)
+			c := newTestClient(t, srv)
+			logFile := filepath.Join(srv.Root, "app.log")
+			w, err := os.Create(logFile)
+			require.NoError(t, err)
+			defer w.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			// Lines covers everything written before the file is first opened
+			lines, errCh := c.FollowChan(ctx, []string{"app.log"}, &net.FollowOption{Lines: math.MaxInt32, UseSFTP: true, PollInterval: time.Millisecond})
+
+			// keep the file growing while it is polled, so that it changes between stats
+			written := make(chan int, 1)
+			go func() {
+				n := 0
+				for deadline := time.Now().Add(500 * time.Millisecond); time.Now().Before(deadline); n++ {
+					_, _ = fmt.Fprintf(w, "line %d\n", n)
+				}
+				written <- n
+			}()
+			total := -1
+			for i := 0; i != total; {
+				select {
+				case l := <-lines:
+					require.Equal(t, fmt.Sprintf("line %d", i), l.Line, "no line is delivered twice")
+					i++
+				case total = <-written:
+				case <-ctx.Done():
+					t.Fatalf("timed out after %d lines", i)
+				}
+			}
+			select {
+			case l := <-lines:
+				t.Fatalf("unexpected line %q", l.Line)
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			cancel()
+			for range lines {
+			}
+			assert.NoError(t, <-errCh)
+		})
+	}
+}
+
+func withoutTime(l net.LogLine) net.LogLine {
+	l.Time = time.Time{}
+	return l
+}
+
+func appendFile(t *testing.T, name, data string) {
+	t.Helper()
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
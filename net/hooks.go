@@ -0,0 +1,127 @@
+package net
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/designinlife/slib/errors"
+	"github.com/designinlife/slib/glog"
+)
+
+// ErrCommandDenied is returned (wrapped) when a BeforeCommandHook vetoes a command.
+var ErrCommandDenied = errors.New("command denied")
+
+// CommandEvent describes one remote command for lifecycle hooks.
+// Duration, ExitCode, byte counts and Err are only set for AfterCommandHook.
+type CommandEvent struct {
+	Host        string
+	Port        int
+	User        string
+	Command     string
+	Start       time.Time
+	Duration    time.Duration
+	ExitCode    int
+	StdoutBytes int64
+	StderrBytes int64
+	Err         error
+}
+
+// BeforeCommandHook runs before a command starts; a non-nil error vetoes the command.
+type BeforeCommandHook func(ctx context.Context, ev *CommandEvent) error
+
+// AfterCommandHook runs after a command has finished, failed or been cancelled.
+type AfterCommandHook func(ctx context.Context, ev *CommandEvent)
+
+// DenyCommandsHook vetoes commands matching any of the patterns, e.g. `rm\s+-rf\s+/(\s|$)`.
+func DenyCommandsHook(patterns ...string) BeforeCommandHook {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		res = append(res, regexp.MustCompile(p))
+	}
+	return func(_ context.Context, ev *CommandEvent) error {
+		for _, re := range res {
+			if re.MatchString(ev.Command) {
+				return fmt.Errorf("matches deny pattern %s", re.String())
+			}
+		}
+		return nil
+	}
+}
+
+// AuditLogHook writes one key=value audit entry per command through logger (the glog default logger if nil).
+func AuditLogHook(logger glog.Logger) AfterCommandHook {
+	return func(_ context.Context, ev *CommandEvent) {
+		errMsg := ""
+		if ev.Err != nil {
+			errMsg = ev.Err.Error()
+		}
+		format := "ssh audit host=%s port=%d user=%s command=%q start=%s duration=%s exit_code=%d stdout_bytes=%d stderr_bytes=%d error=%q"
+		args := []any{ev.Host, ev.Port, ev.User, ev.Command, ev.Start.Format(time.RFC3339Nano), ev.Duration, ev.ExitCode, ev.StdoutBytes, ev.StderrBytes, errMsg}
+		if logger != nil {
+			logger.Infof(format, args...)
+		} else {
+			glog.Infof(format, args...)
+		}
+	}
+}
+
+// commandHooks is embedded by both SSH clients.
+type commandHooks struct {
+	beforeHooks []BeforeCommandHook
+	afterHooks  []AfterCommandHook
+}
+
+// before builds the event and runs the before hooks.
+func (h *commandHooks) before(ctx context.Context, host string, port int, user, cmd string) (*CommandEvent, error) {
+	ev := &CommandEvent{Host: host, Port: port, User: user, Command: cmd, Start: time.Now()}
+	for _, hook := range h.beforeHooks {
+		if err := hook(ctx, ev); err != nil {
+			err = fmt.Errorf("%w: %w", ErrCommandDenied, err)
+			ev.Err = err
+			ev.ExitCode = -1
+			h.after(ctx, ev)
+			return nil, err
+		}
+	}
+	return ev, nil
+}
+
+// after completes the event and runs the after hooks.
+func (h *commandHooks) after(ctx context.Context, ev *CommandEvent) {
+	ev.Duration = time.Since(ev.Start)
+	for _, hook := range h.afterHooks {
+		hook(ctx, ev)
+	}
+}
+
+// exitCodeOf maps a session error to an exit code: 0 on success, the remote status, or -1.
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *ssh.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitStatus()
+	}
+	return -1
+}
+
+// countingWriter counts bytes written through it; w may be nil.
+type countingWriter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	cw.n.Add(int64(len(b)))
+	if cw.w == nil {
+		return len(b), nil
+	}
+	return cw.w.Write(b)
+}
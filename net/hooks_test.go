@@ -0,0 +1,40 @@
+package net_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+func TestCommandHooks(t *testing.T) {
+	srv := newTestServer(t)
+
+	var events []net.CommandEvent
+	c := newTestClient(t, srv,
+		net.WithBeforeCommandHook(net.DenyCommandsHook(`rm\s+-rf\s+/(\s|$)`)),
+		net.WithAfterCommandHook(func(_ context.Context, ev *net.CommandEvent) {
+			events = append(events, *ev)
+		}),
+	)
+	ctx := context.Background()
+
+	_, err := c.Run(ctx, "rm -rf /")
+	assert.ErrorIs(t, err, net.ErrCommandDenied)
+
+	resp, err := c.Run(ctx, "echo abc; exit 4")
+	require.NoError(t, err)
+	assert.Equal(t, 4, resp.ExitCode)
+
+	require.Len(t, events, 2)
+	assert.Equal(t, -1, events[0].ExitCode)
+	assert.ErrorIs(t, events[0].Err, net.ErrCommandDenied)
+	assert.Equal(t, "echo abc; exit 4", events[1].Command)
+	assert.Equal(t, "tester", events[1].User)
+	assert.Equal(t, 4, events[1].ExitCode)
+	assert.Equal(t, int64(4), events[1].StdoutBytes)
+	assert.Positive(t, events[1].Duration)
+}
@@ -0,0 +1,631 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// InventoryHost is a host of an Inventory with its variables merged from all its groups.
+type InventoryHost struct {
+	Name           string
+	Host           string // address to connect to; the name when not set
+	Port           int    // 22 when not set
+	User           string
+	Password       string
+	PrivateKeyFile string
+	JumpHost       string // host[:port]
+	ProxyURL       string
+	Groups         []string       // all groups the host belongs to, including parents and "all"
+	Vars           map[string]any // merged variables, host variables winning over group ones; ansible_* aliases are renamed
+}
+
+// InventoryGroup is a named group of hosts and child groups.
+type InventoryGroup struct {
+	Name     string
+	Hosts    []string
+	Children []string
+	Vars     map[string]any
+}
+
+// Inventory is a set of hosts organized in groups, in the layout of Ansible inventories.
+// The implicit groups "all" and "ungrouped" always exist.
+type Inventory struct {
+	Hosts  map[string]*InventoryHost
+	Groups map[string]*InventoryGroup
+
+	hostVars map[string]map[string]any
+}
+
+// Host variables understood by InventoryHost; the ansible_* spellings are accepted too.
+var inventoryVarAliases = map[string]string{
+	"ansible_host":                 "host",
+	"ansible_port":                 "port",
+	"ansible_user":                 "user",
+	"ansible_password":             "password",
+	"ansible_ssh_private_key_file": "key",
+	"ansible_ssh_pass":             "password",
+}
+
+// LoadInventory reads an inventory file; the format follows the extension (.yml, .yaml, .json,
+// anything else is INI).
+func LoadInventory(name string) (*Inventory, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read inventory %s failed", name)
+	}
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	inv, err := ParseInventory(data, format)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse inventory %s failed", name)
+	}
+	return inv, nil
+}
+
+// ParseInventory parses an inventory in format "yaml" ("yml"), "json" or "ini".
+//
+// YAML and JSON inventories map group names to optional "hosts" (host name to variables),
+// "children" (group name to group) and "vars". INI inventories list hosts with inline
+// key=value variables under [group] sections, plus [group:vars] and [group:children] sections;
+// hosts before the first section are ungrouped.
+func ParseInventory(data []byte, format string) (*Inventory, error) {
+	inv := newInventory()
+	var err error
+	switch format {
+	case "yaml", "yml":
+		var root map[string]any
+		if err = yaml.Unmarshal(data, &root); err == nil {
+			err = inv.addTree(root)
+		}
+	case "json":
+		var root map[string]any
+		if err = json.Unmarshal(data, &root); err == nil {
+			err = inv.addTree(root)
+		}
+	case "ini", "":
+		err = inv.addINI(data)
+	default:
+		return nil, fmt.Errorf("unsupported inventory format %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = inv.resolve(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func newInventory() *Inventory {
+	inv := &Inventory{
+		Hosts:    make(map[string]*InventoryHost),
+		Groups:   make(map[string]*InventoryGroup),
+		hostVars: make(map[string]map[string]any),
+	}
+	inv.group("all")
+	inv.group("ungrouped")
+	return inv
+}
+
+func (inv *Inventory) group(name string) *InventoryGroup {
+	g, ok := inv.Groups[name]
+	if !ok {
+		g = &InventoryGroup{Name: name, Vars: make(map[string]any)}
+		inv.Groups[name] = g
+	}
+	return g
+}
+
+func (inv *Inventory) addHost(group, name string, vars map[string]any) {
+	if _, ok := inv.hostVars[name]; !ok {
+		inv.hostVars[name] = make(map[string]any)
+	}
+	for k, v := range vars {
+		inv.hostVars[name][k] = v
+	}
+	g := inv.group(group)
+	for _, h := range g.Hosts {
+		if h == name {
+			return
+		}
+	}
+	g.Hosts = append(g.Hosts, name)
+}
+
+func (inv *Inventory) addChild(parent, child string) {
+	g := inv.group(parent)
+	inv.group(child)
+	for _, c := range g.Children {
+		if c == child {
+			return
+		}
+	}
+	g.Children = append(g.Children, child)
+}
+
+// addTree adds the groups of a decoded YAML or JSON inventory.
+func (inv *Inventory) addTree(root map[string]any) error {
+	for name, v := range root {
+		if err := inv.addTreeGroup(name, v); err != nil {
+			return err
+		}
+		if name != "all" {
+			inv.addChild("all", name)
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) addTreeGroup(name string, v any) error {
+	g := inv.group(name)
+	if v == nil {
+		return nil
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("group %s: expected a mapping", name)
+	}
+	for key, sub := range m {
+		if sub == nil {
+			continue
+		}
+		entries, ok := sub.(map[string]any)
+		if !ok {
+			return fmt.Errorf("group %s: %s must be a mapping", name, key)
+		}
+		switch key {
+		case "hosts":
+			for host, hv := range entries {
+				vars, ok1 := hv.(map[string]any)
+				if hv != nil && !ok1 {
+					return fmt.Errorf("group %s: variables of host %s must be a mapping", name, host)
+				}
+				inv.addHost(name, host, vars)
+			}
+		case "children":
+			for child, cv := range entries {
+				inv.addChild(name, child)
+				if err := inv.addTreeGroup(child, cv); err != nil {
+					return err
+				}
+			}
+		case "vars":
+			for k, val := range entries {
+				g.Vars[k] = val
+			}
+		default:
+			return fmt.Errorf("group %s: unknown key %s", name, key)
+		}
+	}
+	return nil
+}
+
+// addINI adds the sections of an INI inventory.
+func (inv *Inventory) addINI(data []byte) error {
+	group, kind := "ungrouped", ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("line %d: invalid section %s", n, line)
+			}
+			group, kind, _ = strings.Cut(line[1:len(line)-1], ":")
+			if kind != "" && kind != "vars" && kind != "children" {
+				return fmt.Errorf("line %d: unknown section type %s", n, kind)
+			}
+			inv.group(group)
+			if group != "all" {
+				inv.addChild("all", group)
+			}
+			continue
+		}
+
+		fields, err := splitINIFields(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		switch kind {
+		case "vars":
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				return fmt.Errorf("line %d: expected key=value", n)
+			}
+			vals, err1 := splitINIFields(strings.TrimSpace(v))
+			if err1 != nil {
+				return fmt.Errorf("line %d: %w", n, err1)
+			}
+			inv.Groups[group].Vars[strings.TrimSpace(k)] = strings.Join(vals, " ")
+		case "children":
+			inv.addChild(group, fields[0])
+		default:
+			vars := make(map[string]any)
+			for _, f := range fields[1:] {
+				k, v, ok := strings.Cut(f, "=")
+				if !ok {
+					return fmt.Errorf("line %d: expected key=value, got %s", n, f)
+				}
+				vars[k] = v
+			}
+			inv.addHost(group, fields[0], vars)
+		}
+	}
+	return scanner.Err()
+}
+
+// splitINIFields splits on spaces, honoring single and double quotes.
+func splitINIFields(s string) ([]string, error) {
+	var (
+		fields []string
+		cur    strings.Builder
+		quote  rune
+		inWord bool
+	)
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+// resolve builds the hosts with their group memberships and merged variables. Group variables
+// apply from the least to the most nested group ("all" first), then host variables.
+func (inv *Inventory) resolve() error {
+	depth := make(map[string]int)
+	var walk func(name string, d int, stack map[string]bool) error
+	walk = func(name string, d int, stack map[string]bool) error {
+		if stack[name] {
+			return fmt.Errorf("group %s is its own descendant", name)
+		}
+		if d > depth[name] {
+			depth[name] = d
+		}
+		stack[name] = true
+		defer delete(stack, name)
+		for _, c := range inv.Groups[name].Children {
+			if err := walk(c, d+1, stack); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk("all", 0, make(map[string]bool)); err != nil {
+		return err
+	}
+
+	// parents of each group, for the transitive membership
+	parents := make(map[string][]string)
+	for _, g := range inv.Groups {
+		for _, c := range g.Children {
+			parents[c] = append(parents[c], g.Name)
+		}
+	}
+	directGroups := make(map[string][]string)
+	for _, g := range inv.Groups {
+		for _, h := range g.Hosts {
+			directGroups[h] = append(directGroups[h], g.Name)
+		}
+	}
+
+	for name, hostVars := range inv.hostVars {
+		member := map[string]bool{"all": true}
+		ungrouped := true
+		for _, g := range directGroups[name] {
+			if g != "all" && g != "ungrouped" {
+				ungrouped = false
+			}
+		}
+		queue := append([]string(nil), directGroups[name]...)
+		for len(queue) > 0 {
+			g := queue[0]
+			queue = queue[1:]
+			if member[g] {
+				continue
+			}
+			member[g] = true
+			queue = append(queue, parents[g]...)
+		}
+		member["ungrouped"] = ungrouped
+		if !ungrouped {
+			delete(member, "ungrouped")
+		}
+
+		groups := make([]string, 0, len(member))
+		for g := range member {
+			groups = append(groups, g)
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			if depth[groups[i]] != depth[groups[j]] {
+				return depth[groups[i]] < depth[groups[j]]
+			}
+			return groups[i] < groups[j]
+		})
+
+		vars := make(map[string]any)
+		for _, g := range groups {
+			for k, v := range canonicalInventoryVars(inv.Groups[g].Vars) {
+				vars[k] = v
+			}
+		}
+		for k, v := range canonicalInventoryVars(hostVars) {
+			vars[k] = v
+		}
+		h, err := newInventoryHost(name, vars)
+		if err != nil {
+			return err
+		}
+		sort.Strings(groups)
+		h.Groups = groups
+		inv.Hosts[name] = h
+	}
+
+	inv.Groups["ungrouped"].Hosts = nil
+	for name, h := range inv.Hosts {
+		for _, g := range h.Groups {
+			if g == "ungrouped" {
+				inv.Groups["ungrouped"].Hosts = append(inv.Groups["ungrouped"].Hosts, name)
+			}
+		}
+	}
+	sort.Strings(inv.Groups["ungrouped"].Hosts)
+	return nil
+}
+
+// canonicalInventoryVars renames the ansible_* aliases to their canonical names, so that a host
+// variable overrides a group variable whatever the spelling. Within one map the canonical name wins.
+func canonicalInventoryVars(vars map[string]any) map[string]any {
+	out := make(map[string]any, len(vars))
+	for k, v := range vars {
+		if _, ok := inventoryVarAliases[k]; !ok {
+			out[k] = v
+		}
+	}
+	aliases := make([]string, 0, len(vars))
+	for k := range vars {
+		if _, ok := inventoryVarAliases[k]; ok {
+			aliases = append(aliases, k)
+		}
+	}
+	sort.Strings(aliases)
+	for _, k := range aliases {
+		if _, ok := out[inventoryVarAliases[k]]; !ok {
+			out[inventoryVarAliases[k]] = vars[k]
+		}
+	}
+	return out
+}
+
+func newInventoryHost(name string, vars map[string]any) (*InventoryHost, error) {
+	h := &InventoryHost{Name: name, Host: name, Port: 22, Vars: vars}
+	for k, v := range vars {
+		s := fmt.Sprint(v)
+		switch k {
+		case "host":
+			h.Host = s
+		case "port":
+			port, err := strconv.Atoi(s)
+			if err != nil || port <= 0 || port > 65535 {
+				return nil, fmt.Errorf("host %s: invalid port %v", name, v)
+			}
+			h.Port = port
+		case "user":
+			h.User = s
+		case "password":
+			h.Password = s
+		case "key":
+			h.PrivateKeyFile = s
+		case "jump":
+			h.JumpHost = s
+		case "proxy":
+			h.ProxyURL = s
+		}
+	}
+	return h, nil
+}
+
+// Client returns a RichSSHClient configured from the host variables; opts are applied afterwards,
+// e.g. to supply credentials missing from the inventory.
+func (h *InventoryHost) Client(opts ...RichSSHClientOption) (*RichSSHClient, error) {
+	var base []RichSSHClientOption
+	if h.Password != "" {
+		base = append(base, WithPassword(h.Password))
+	}
+	if h.PrivateKeyFile != "" {
+		base = append(base, WithPrivateKeyFile(h.PrivateKeyFile))
+	}
+	if h.JumpHost != "" {
+		host, port := h.JumpHost, 22
+		if hh, p, err := net.SplitHostPort(h.JumpHost); err == nil {
+			if port, err = strconv.Atoi(p); err != nil {
+				return nil, fmt.Errorf("host %s: invalid jump host %s", h.Name, h.JumpHost)
+			}
+			host = hh
+		}
+		base = append(base, WithJumpHost(host, port))
+	}
+	if h.ProxyURL != "" {
+		base = append(base, WithProxyURL(h.ProxyURL))
+	}
+	return NewRichSSHClient(h.Host, h.Port, h.User, append(base, opts...)...), nil
+}
+
+// Select returns the hosts matching pattern, sorted by name. A pattern is a list of terms separated
+// by ':' or ',': host or group names with shell wildcards, "&term" to intersect and "!term" to exclude,
+// e.g. "web:&prod:!web03". Unions apply first, then intersections, then exclusions.
+func (inv *Inventory) Select(pattern string) ([]*InventoryHost, error) {
+	terms := strings.FieldsFunc(pattern, func(r rune) bool { return r == ':' || r == ',' })
+	if len(terms) == 0 {
+		return nil, errors.New("empty host pattern")
+	}
+
+	selected := make(map[string]bool)
+	var intersect, exclude []string
+	for _, term := range terms {
+		switch {
+		case strings.HasPrefix(term, "&"):
+			intersect = append(intersect, term[1:])
+		case strings.HasPrefix(term, "!"):
+			exclude = append(exclude, term[1:])
+		default:
+			matched, err := inv.match(term)
+			if err != nil {
+				return nil, err
+			}
+			for h := range matched {
+				selected[h] = true
+			}
+		}
+	}
+	for _, term := range intersect {
+		matched, err := inv.match(term)
+		if err != nil {
+			return nil, err
+		}
+		for h := range selected {
+			if !matched[h] {
+				delete(selected, h)
+			}
+		}
+	}
+	for _, term := range exclude {
+		matched, err := inv.match(term)
+		if err != nil {
+			return nil, err
+		}
+		for h := range matched {
+			delete(selected, h)
+		}
+	}
+
+	hosts := make([]*InventoryHost, 0, len(selected))
+	for name := range selected {
+		hosts = append(hosts, inv.Hosts[name])
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	return hosts, nil
+}
+
+// match returns the hosts named by term or belonging to a group named by term.
+func (inv *Inventory) match(term string) (map[string]bool, error) {
+	if term == "*" {
+		term = "all"
+	}
+	matched := make(map[string]bool)
+	for name, h := range inv.Hosts {
+		ok, err := path.Match(term, name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host pattern %s: %w", term, err)
+		}
+		if ok {
+			matched[name] = true
+			continue
+		}
+		for _, g := range h.Groups {
+			if ok, _ = path.Match(term, g); ok {
+				matched[name] = true
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+// Clients returns configured clients for the hosts matching pattern, in Select order.
+func (inv *Inventory) Clients(pattern string, opts ...RichSSHClientOption) ([]*RichSSHClient, error) {
+	hosts, err := inv.Select(pattern)
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]*RichSSHClient, 0, len(hosts))
+	for _, h := range hosts {
+		c, err1 := h.Client(opts...)
+		if err1 != nil {
+			return nil, err1
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+// ForEachHost calls fn for every host with a fresh client, at most parallel at a time (all at once
+// when parallel <= 0), and closes the clients afterwards. It returns the error of each host by name;
+// hosts that succeeded have no entry.
+func ForEachHost(ctx context.Context, hosts []*InventoryHost, parallel int, fn func(ctx context.Context, h *InventoryHost, c *RichSSHClient) error, opts ...RichSSHClientOption) map[string]error {
+	if parallel <= 0 || parallel > len(hosts) {
+		parallel = len(hosts)
+	}
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make(map[string]error)
+		sem  = make(chan struct{}, max(parallel, 1))
+	)
+	for _, h := range hosts {
+		wg.Add(1)
+		go func(h *InventoryHost) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				mu.Lock()
+				errs[h.Name] = ctx.Err()
+				mu.Unlock()
+				return
+			}
+
+			c, err := h.Client(opts...)
+			if err == nil {
+				err = fn(ctx, h, c)
+				c.Close()
+			}
+			if err != nil {
+				mu.Lock()
+				errs[h.Name] = err
+				mu.Unlock()
+			}
+		}(h)
+	}
+	wg.Wait()
+	return errs
+}
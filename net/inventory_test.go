@@ -0,0 +1,165 @@
+package net_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+const yamlInventory = `
+all:
+  vars:
+    user: deploy
+  hosts:
+    bastion:
+      host: 10.0.0.1
+web:
+  vars:
+    port: 2222
+  hosts:
+    web01:
+      ansible_host: 10.0.1.1
+    web02:
+    web03:
+      port: 2200
+db:
+  hosts:
+    db01:
+      key: ~/.ssh/db
+      jump: bastion.example.com:2022
+prod:
+  vars:
+    proxy: socks5://proxy:1080
+  children:
+    web:
+    db:
+`
+
+const iniInventory = `
+bastion host=10.0.0.1
+
+[web]
+web01 ansible_host=10.0.1.1
+web02
+web03 port=2200
+
+[web:vars]
+port=2222
+
+[db]
+db01 key=~/.ssh/db jump=bastion.example.com:2022
+
+[prod:children]
+web
+db
+
+[prod:vars]
+proxy="socks5://proxy:1080"
+
+[all:vars]
+user=deploy
+`
+
+func TestInventory(t *testing.T) {
+	for format, data := range map[string]string{"yaml": yamlInventory, "ini": iniInventory} {
+		t.Run(format, func(t *testing.T) {
+			inv, err := net.ParseInventory([]byte(data), format)
+			require.NoError(t, err)
+			require.Len(t, inv.Hosts, 5)
+
+			web01 := inv.Hosts["web01"]
+			assert.Equal(t, "10.0.1.1", web01.Host)
+			assert.Equal(t, 2222, web01.Port)
+			assert.Equal(t, "deploy", web01.User)
+			assert.Equal(t, "socks5://proxy:1080", web01.ProxyURL)
+			assert.Equal(t, []string{"all", "prod", "web"}, web01.Groups)
+			assert.Equal(t, 2200, inv.Hosts["web03"].Port)
+
+			db01 := inv.Hosts["db01"]
+			assert.Equal(t, "~/.ssh/db", db01.PrivateKeyFile)
+			assert.Equal(t, "bastion.example.com:2022", db01.JumpHost)
+			c, err := db01.Client()
+			require.NoError(t, err)
+			assert.Equal(t, "bastion.example.com", c.JumpSSHHost)
+			assert.Equal(t, 2022, c.JumpSSHPort)
+			assert.Equal(t, []string{"all", "ungrouped"}, inv.Hosts["bastion"].Groups)
+			assert.Equal(t, 22, inv.Hosts["bastion"].Port)
+
+			for pattern, want := range map[string][]string{
+				"web:&prod:!web03": {"web01", "web02"},
+				"all:!prod":        {"bastion"},
+				"web0*,db":         {"db01", "web01", "web02", "web03"},
+				"ungrouped":        {"bastion"},
+				"prod:&db":         {"db01"},
+			} {
+				hosts, err1 := inv.Select(pattern)
+				require.NoError(t, err1)
+				var names []string
+				for _, h := range hosts {
+					names = append(names, h.Name)
+				}
+				assert.Equal(t, want, names, pattern)
+			}
+		})
+	}
+}
+
+func TestInventoryVarAliases(t *testing.T) {
+	inv, err := net.ParseInventory([]byte(`
+web:
+  vars:
+    ansible_user: root
+    ansible_port: 2222
+  hosts:
+    w1:
+      user: deploy
+    w2:
+      ansible_host: 10.0.0.2
+      host: 10.0.0.3
+`), "yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "deploy", inv.Hosts["w1"].User)
+	assert.Equal(t, 2222, inv.Hosts["w1"].Port)
+	assert.Equal(t, "root", inv.Hosts["w2"].User)
+	assert.Equal(t, "10.0.0.3", inv.Hosts["w2"].Host)
+	assert.NotContains(t, inv.Hosts["w1"].Vars, "ansible_user")
+}
+
+func TestInventoryErrors(t *testing.T) {
+	_, err := net.ParseInventory([]byte("[a:children]\nb\n[b:children]\na\n"), "ini")
+	assert.Error(t, err)
+	_, err = net.ParseInventory([]byte("web:\n  hosts:\n    w1:\n      port: nope\n"), "yaml")
+	assert.Error(t, err)
+	_, err = net.ParseInventory([]byte("{}"), "toml")
+	assert.Error(t, err)
+}
+
+func TestLoadInventoryForEachHost(t *testing.T) {
+	srv := newTestServer(t)
+	name := filepath.Join(t.TempDir(), "hosts.json")
+	require.NoError(t, os.WriteFile(name, []byte(`{"app": {"hosts": {"a": {"host": "`+srv.Host+`", "port": `+strconv.Itoa(srv.Port)+`}, "b": {"host": "`+srv.Host+`", "port": 1}}, "vars": {"user": "tester", "password": "secret"}}}`), 0o644))
+
+	inv, err := net.LoadInventory(name)
+	require.NoError(t, err)
+	hosts, err := inv.Select("app")
+	require.NoError(t, err)
+
+	var ran atomic.Int32
+	errs := net.ForEachHost(context.Background(), hosts, 2, func(ctx context.Context, h *net.InventoryHost, c *net.RichSSHClient) error {
+		ran.Add(1)
+		_, err1 := c.Run(ctx, "true")
+		return err1
+	}, net.WithDialTimeout(2*time.Second))
+	assert.Equal(t, int32(2), ran.Load())
+	assert.Len(t, errs, 1)
+	assert.Error(t, errs["b"])
+}
@@ -0,0 +1,251 @@
+package net
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// PackageManager is a supported system package manager.
+type PackageManager string
+
+const (
+	PackageManagerApt    PackageManager = "apt"
+	PackageManagerDnf    PackageManager = "dnf"
+	PackageManagerYum    PackageManager = "yum"
+	PackageManagerApk    PackageManager = "apk"
+	PackageManagerZypper PackageManager = "zypper"
+)
+
+// PackageInfo is the installation state of a package. A package given as "name=version" (where
+// version may contain * wildcards) is only reported installed in a matching version.
+type PackageInfo struct {
+	Name      string
+	Installed bool
+	Version   string // the installed version, also when it does not match the requested one
+}
+
+// PackageResult is the outcome of InstallPackages or RemovePackages.
+type PackageResult struct {
+	Manager PackageManager
+	Changed bool
+	// Packages are the packages actually installed or removed.
+	Packages []string
+}
+
+var (
+	packageNameRe    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.+:@-]*$`)
+	packageVersionRe = regexp.MustCompile(`^[A-Za-z0-9_.+:~*-]+$`)
+)
+
+// DetectPackageManager returns the package manager of the host: apt, dnf, yum, apk or zypper,
+// in that order of preference.
+func (c *RichSSHClient) DetectPackageManager(ctx context.Context) (PackageManager, error) {
+	resp, err := c.Run(ctx, `for pm in apt-get dnf yum apk zypper; do if command -v $pm >/dev/null 2>&1; then echo $pm; exit 0; fi; done; exit 1`)
+	if err != nil {
+		return "", errors.Wrap(err, "detect package manager failed")
+	}
+	if resp.ExitCode != 0 {
+		return "", errors.New("no supported package manager found")
+	}
+	pm := strings.TrimSpace(string(resp.Stdout))
+	if pm == "apt-get" {
+		return PackageManagerApt, nil
+	}
+	return PackageManager(pm), nil
+}
+
+// Packages returns the state of the named packages, in order.
+func (c *RichSSHClient) Packages(ctx context.Context, names ...string) ([]PackageInfo, error) {
+	pm, err := c.DetectPackageManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.queryPackages(ctx, pm, names)
+}
+
+func (c *RichSSHClient) queryPackages(ctx context.Context, pm PackageManager, names []string) ([]PackageInfo, error) {
+	if _, err := quotePackageNames(pm, names); err != nil {
+		return nil, err
+	}
+	bare := make([]string, 0, len(names))
+	for _, name := range names {
+		bare = append(bare, shellQuote(splitPackagePin(name)[0]))
+	}
+	quoted := strings.Join(bare, " ")
+
+	var cmd string
+	switch pm {
+	case PackageManagerApt:
+		cmd = `dpkg-query -W -f='${Package}\t${db:Status-Status}\t${Version}\n' -- ` + quoted + ` 2>/dev/null; true`
+	case PackageManagerDnf, PackageManagerYum, PackageManagerZypper:
+		cmd = `rpm -q --qf '%{NAME}\tinstalled\t%{VERSION}-%{RELEASE}\n' ` + quoted + `; true`
+	case PackageManagerApk:
+		cmd = `apk list -I 2>/dev/null; true`
+	default:
+		return nil, fmt.Errorf("unsupported package manager %s", pm)
+	}
+	resp, err := c.Run(ctx, cmd)
+	if err != nil {
+		return nil, errors.Wrap(err, "query packages failed")
+	}
+
+	installed := make(map[string]string)
+	for _, line := range strings.Split(string(resp.Stdout), "\n") {
+		if pm == PackageManagerApk {
+			if name, version, ok := parseApkListLine(line); ok {
+				installed[name] = version
+			}
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		// rpm prints "package x is not installed" lines, which have no tabs
+		if len(fields) == 3 && fields[1] == "installed" {
+			installed[fields[0]] = fields[2]
+		}
+	}
+
+	infos := make([]PackageInfo, 0, len(names))
+	for _, name := range names {
+		pin := splitPackagePin(name)
+		version, ok := installed[pin[0]]
+		if !ok {
+			// name:arch for multi-arch packages
+			version, ok = installed[strings.SplitN(pin[0], ":", 2)[0]]
+		}
+		if ok && pin[1] != "" {
+			ok = packageVersionMatches(pin[1], version)
+		}
+		infos = append(infos, PackageInfo{Name: name, Installed: ok, Version: version})
+	}
+	return infos, nil
+}
+
+// splitPackagePin splits "name=version" into name and version; the version is empty when not pinned.
+func splitPackagePin(name string) [2]string {
+	n, v, _ := strings.Cut(name, "=")
+	return [2]string{n, v}
+}
+
+// packageVersionMatches reports whether version matches the pattern, with or without the release
+// suffix (e.g. the "-1" of "1.24.0-1"), so that "1.24.0", "1.24.0-1" and "1.24*" all match 1.24.0-1.
+func packageVersionMatches(pattern, version string) bool {
+	candidates := []string{version}
+	if i := strings.LastIndex(version, "-"); i > 0 {
+		candidates = append(candidates, version[:i])
+	}
+	for _, v := range candidates {
+		if ok, _ := path.Match(pattern, v); ok || pattern == v {
+			return true
+		}
+	}
+	return false
+}
+
+var apkPackageRe = regexp.MustCompile(`^(.+)-([0-9][^-]*-r[0-9]+)$`)
+
+// parseApkListLine parses "busybox-1.36.1-r15 x86_64 {busybox} (GPL-2.0-only) [installed]".
+func parseApkListLine(line string) (name, version string, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.Contains(line, "[installed") {
+		return "", "", false
+	}
+	m := apkPackageRe.FindStringSubmatch(fields[0])
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// InstallPackages installs the packages that are not installed yet, non-interactively.
+func (c *RichSSHClient) InstallPackages(ctx context.Context, names ...string) (*PackageResult, error) {
+	return c.changePackages(ctx, names, true)
+}
+
+// RemovePackages removes the packages that are installed.
+func (c *RichSSHClient) RemovePackages(ctx context.Context, names ...string) (*PackageResult, error) {
+	return c.changePackages(ctx, names, false)
+}
+
+func (c *RichSSHClient) changePackages(ctx context.Context, names []string, install bool) (*PackageResult, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no packages given")
+	}
+	pm, err := c.DetectPackageManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := c.queryPackages(ctx, pm, names)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &PackageResult{Manager: pm}
+	for _, info := range infos {
+		if info.Installed != install {
+			res.Packages = append(res.Packages, info.Name)
+		}
+	}
+	if len(res.Packages) == 0 {
+		return res, nil
+	}
+
+	targets := res.Packages
+	if !install {
+		// a pin only selects what to remove
+		targets = make([]string, 0, len(res.Packages))
+		for _, name := range res.Packages {
+			targets = append(targets, splitPackagePin(name)[0])
+		}
+	}
+	quoted, _ := quotePackageNames(pm, targets)
+	var cmd string
+	switch {
+	case pm == PackageManagerApt && install:
+		cmd = "DEBIAN_FRONTEND=noninteractive apt-get install -y -q -- " + quoted
+	case pm == PackageManagerApt:
+		cmd = "DEBIAN_FRONTEND=noninteractive apt-get remove -y -q -- " + quoted
+	case (pm == PackageManagerDnf || pm == PackageManagerYum) && install:
+		cmd = string(pm) + " install -y -q " + quoted
+	case pm == PackageManagerDnf || pm == PackageManagerYum:
+		cmd = string(pm) + " remove -y -q " + quoted
+	case pm == PackageManagerApk && install:
+		cmd = "apk add --no-progress -q " + quoted
+	case pm == PackageManagerApk:
+		cmd = "apk del --no-progress -q " + quoted
+	case pm == PackageManagerZypper && install:
+		cmd = "zypper --non-interactive --quiet install -- " + quoted
+	default:
+		cmd = "zypper --non-interactive --quiet remove -- " + quoted
+	}
+	resp, err := c.Run(ctx, cmd)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s failed", cmd)
+	}
+	if resp.ExitCode != 0 {
+		return nil, fmt.Errorf("%s failed with exit code %d: %s", cmd, resp.ExitCode, strings.TrimSpace(string(resp.Stderr)))
+	}
+	res.Changed = true
+	return res, nil
+}
+
+// quotePackageNames validates and quotes names for the command line of pm, writing version pins
+// the way it expects them ("name-version" for dnf and yum, "name=version" for the others).
+func quotePackageNames(pm PackageManager, names []string) (string, error) {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		pin := splitPackagePin(name)
+		if !packageNameRe.MatchString(pin[0]) || (strings.Contains(name, "=") && !packageVersionRe.MatchString(pin[1])) {
+			return "", fmt.Errorf("invalid package name %q", name)
+		}
+		if pin[1] != "" && (pm == PackageManagerDnf || pm == PackageManagerYum) {
+			name = pin[0] + "-" + pin[1]
+		}
+		quoted = append(quoted, shellQuote(name))
+	}
+	return strings.Join(quoted, " "), nil
+}
@@ -0,0 +1,204 @@
+package net_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+	"github.com/designinlife/slib/net/sshtest"
+)
+
+// fakeDpkgQuery and fakeAptGet keep "name version" lines in $FAKE_STATE/pkgs.
+const fakeDpkgQuery = `#!/bin/sh
+shift 3
+touch "$FAKE_STATE/pkgs"
+for p in "$@"; do
+	v=$(awk -v p="$p" '$1 == p {print $2}' "$FAKE_STATE/pkgs")
+	if [ -n "$v" ]; then printf '%s\tinstalled\t%s\n' "$p" "$v"; else echo "dpkg-query: no packages found matching $p" >&2; fi
+done
+`
+
+const fakeAptGet = `#!/bin/sh
+[ "$DEBIAN_FRONTEND" = noninteractive ] || exit 9
+cmd=$1; shift 4
+for p in "$@"; do
+	case "$cmd" in
+	install)
+		case "$p" in *=*) v=${p#*=}; p=${p%%=*} ;; *) v=1.0-1 ;; esac
+		grep -v "^$p " "$FAKE_STATE/pkgs" > "$FAKE_STATE/pkgs.new"; mv "$FAKE_STATE/pkgs.new" "$FAKE_STATE/pkgs"
+		echo "$p $v" >> "$FAKE_STATE/pkgs" ;;
+	remove) grep -v "^$p " "$FAKE_STATE/pkgs" > "$FAKE_STATE/pkgs.new"; mv "$FAKE_STATE/pkgs.new" "$FAKE_STATE/pkgs" ;;
+	esac
+done
+`
+
+func TestPackages(t *testing.T) {
+	srv := newFakeToolsServer(t, map[string]string{"dpkg-query": fakeDpkgQuery, "apt-get": fakeAptGet})
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	pm, err := c.DetectPackageManager(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, net.PackageManagerApt, pm)
+
+	res, err := c.InstallPackages(ctx, "nginx", "curl")
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	assert.Equal(t, []string{"nginx", "curl"}, res.Packages)
+
+	res, err = c.InstallPackages(ctx, "nginx", "jq")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"jq"}, res.Packages)
+
+	infos, err := c.Packages(ctx, "curl", "vim")
+	require.NoError(t, err)
+	assert.Equal(t, []net.PackageInfo{{Name: "curl", Installed: true, Version: "1.0-1"}, {Name: "vim"}}, infos)
+
+	res, err = c.RemovePackages(ctx, "curl", "vim")
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	assert.Equal(t, []string{"curl"}, res.Packages)
+	res, err = c.RemovePackages(ctx, "curl")
+	require.NoError(t, err)
+	assert.False(t, res.Changed)
+
+	// a pinned package is installed only in a matching version
+	res, err = c.InstallPackages(ctx, "nginx=1.24.0-2", "jq=1.0*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nginx=1.24.0-2"}, res.Packages)
+	res, err = c.InstallPackages(ctx, "nginx=1.24.0-2")
+	require.NoError(t, err)
+	assert.False(t, res.Changed)
+	infos, err = c.Packages(ctx, "nginx=1.24*", "nginx=1.25*")
+	require.NoError(t, err)
+	assert.True(t, infos[0].Installed)
+	assert.False(t, infos[1].Installed)
+	assert.Equal(t, "1.24.0-2", infos[1].Version)
+
+	for _, name := range []string{"nginx; reboot", "nginx=", "nginx=1 2", "nginx>=1.2"} {
+		_, err = c.InstallPackages(ctx, name)
+		assert.Error(t, err, name)
+	}
+}
+
+// fakeRpm and fakeDnf keep "name version" lines in $FAKE_STATE/pkgs.
+const fakeRpm = `#!/bin/sh
+shift 3
+touch "$FAKE_STATE/pkgs"
+for p in "$@"; do
+	v=$(awk -v p="$p" '$1 == p {print $2}' "$FAKE_STATE/pkgs")
+	if [ -n "$v" ]; then printf '%s\tinstalled\t%s\n' "$p" "$v"; else echo "package $p is not installed"; fi
+done
+`
+
+const fakeDnf = `#!/bin/sh
+cmd=$1; shift 3
+touch "$FAKE_STATE/pkgs"
+for p in "$@"; do
+	case "$p" in *-[0-9]*) n=${p%%-[0-9]*}; v=${p#"$n"-} ;; *) n=$p; v=1.0-1.el9 ;; esac
+	grep -v "^$n " "$FAKE_STATE/pkgs" > "$FAKE_STATE/pkgs.new"; mv "$FAKE_STATE/pkgs.new" "$FAKE_STATE/pkgs"
+	[ "$cmd" = install ] && echo "$n $v" >> "$FAKE_STATE/pkgs"
+done
+true
+`
+
+// fakeApk keeps "name version" lines in $FAKE_STATE/pkgs and lists them like apk.
+const fakeApk = `#!/bin/sh
+touch "$FAKE_STATE/pkgs"
+case "$1" in
+list)
+	echo "musl-1.2.4-r2 x86_64 {musl} (MIT) [installed]"
+	echo "libcurl-8.5.0-r0 x86_64 {curl} (curl) [upgradable from: libcurl-8.4.0-r0]"
+	while read -r n v; do echo "$n-$v x86_64 {$n} (MIT) [installed]"; done < "$FAKE_STATE/pkgs" ;;
+add|del)
+	cmd=$1; shift 3
+	for p in "$@"; do
+		case "$p" in *=*) v=${p#*=}; p=${p%%=*} ;; *) v=1.0-r0 ;; esac
+		grep -v "^$p " "$FAKE_STATE/pkgs" > "$FAKE_STATE/pkgs.new"; mv "$FAKE_STATE/pkgs.new" "$FAKE_STATE/pkgs"
+		[ "$cmd" = add ] && echo "$p $v" >> "$FAKE_STATE/pkgs"
+	done
+	true ;;
+esac
+`
+
+// newFakePackagesServer is newFakeToolsServer with only the fake tools and a few basic commands on
+// PATH, so that the package manager of the test host is not detected instead.
+func newFakePackagesServer(t *testing.T, tools map[string]string) *sshtest.Server {
+	t.Helper()
+	bin, state := t.TempDir(), t.TempDir()
+	for _, tool := range []string{"sh", "awk", "grep", "mv", "touch"} {
+		p, err := exec.LookPath(tool)
+		require.NoError(t, err)
+		require.NoError(t, os.Symlink(p, filepath.Join(bin, tool)))
+	}
+	for name, script := range tools {
+		require.NoError(t, os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755))
+	}
+	return newTestServer(t, sshtest.WithExecHandler(func(req *sshtest.ExecRequest) int {
+		req.Env = append(req.Env, "PATH="+bin, "FAKE_STATE="+state)
+		return sshtest.ShellExec(req)
+	}))
+}
+
+func TestPackagesRpm(t *testing.T) {
+	srv := newFakePackagesServer(t, map[string]string{"rpm": fakeRpm, "dnf": fakeDnf})
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	pm, err := c.DetectPackageManager(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, net.PackageManagerDnf, pm)
+
+	res, err := c.InstallPackages(ctx, "nginx=1.24.0", "git")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nginx=1.24.0", "git"}, res.Packages)
+	infos, err := c.Packages(ctx, "nginx", "nginx=1.24.0", "git", "vim")
+	require.NoError(t, err)
+	assert.Equal(t, []net.PackageInfo{
+		{Name: "nginx", Installed: true, Version: "1.24.0"},
+		{Name: "nginx=1.24.0", Installed: true, Version: "1.24.0"},
+		{Name: "git", Installed: true, Version: "1.0-1.el9"},
+		{Name: "vim"},
+	}, infos)
+
+	res, err = c.InstallPackages(ctx, "nginx=1.24.0", "git")
+	require.NoError(t, err)
+	assert.False(t, res.Changed)
+	res, err = c.RemovePackages(ctx, "git", "vim")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"git"}, res.Packages)
+}
+
+func TestPackagesApk(t *testing.T) {
+	srv := newFakePackagesServer(t, map[string]string{"apk": fakeApk})
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	pm, err := c.DetectPackageManager(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, net.PackageManagerApk, pm)
+
+	infos, err := c.Packages(ctx, "musl", "libcurl")
+	require.NoError(t, err)
+	assert.Equal(t, []net.PackageInfo{{Name: "musl", Installed: true, Version: "1.2.4-r2"}, {Name: "libcurl"}}, infos)
+
+	res, err := c.InstallPackages(ctx, "musl", "py3-yaml", "nginx=1.24.0-r1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"py3-yaml", "nginx=1.24.0-r1"}, res.Packages)
+	infos, err = c.Packages(ctx, "py3-yaml", "nginx=1.24.0")
+	require.NoError(t, err)
+	assert.Equal(t, []net.PackageInfo{{Name: "py3-yaml", Installed: true, Version: "1.0-r0"}, {Name: "nginx=1.24.0", Installed: true, Version: "1.24.0-r1"}}, infos)
+
+	res, err = c.RemovePackages(ctx, "nginx=1.24*")
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	infos, err = c.Packages(ctx, "nginx")
+	require.NoError(t, err)
+	assert.False(t, infos[0].Installed)
+}
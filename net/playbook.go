@@ -0,0 +1,574 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// Playbook is a list of tasks run in order against hosts, with handlers run at the end for the
+// tasks that changed something. String fields of the steps and When are Go templates over the
+// variables: the playbook and host variables plus the results registered by earlier tasks.
+type Playbook struct {
+	Name     string         `yaml:"name"`
+	Vars     map[string]any `yaml:"vars"`
+	Tasks    []*Task        `yaml:"tasks"`
+	Handlers []*Task        `yaml:"handlers"`
+
+	// baseDir resolves relative local paths, the playbook file's directory when loaded from a file
+	baseDir string
+}
+
+// Task is one step. Exactly one of Command, Upload, Template, Service, Line and Block must be set.
+type Task struct {
+	Name string `yaml:"name"`
+	// When skips the task unless it renders to something other than "", "false", "0" or "no".
+	// A bare expression like `eq .kernel.ExitCode 0` is wrapped in {{ }}. Referring to an undefined
+	// variable fails the task; `index . "name"` tests a variable that may be unset.
+	When string `yaml:"when"`
+	// Register stores the TaskResult under this variable name.
+	Register string `yaml:"register"`
+	// Notify names handlers to run once after all tasks when this task changed something.
+	Notify       []string `yaml:"notify"`
+	IgnoreErrors bool     `yaml:"ignore_errors"`
+
+	Command  string        `yaml:"command"`
+	Upload   *UploadStep   `yaml:"upload"`
+	Template *TemplateStep `yaml:"template"`
+	Service  *ServiceStep  `yaml:"service"`
+	Line     *LineStep     `yaml:"line"`
+	Block    *BlockStep    `yaml:"block"`
+}
+
+// UploadStep copies a local file when the remote one differs (by SHA-256).
+type UploadStep struct {
+	Src  string `yaml:"src"`
+	Dest string `yaml:"dest"`
+	Mode string `yaml:"mode"` // octal, e.g. "0644"
+}
+
+// TemplateStep renders a Go template (from the local file Src or inline Content) with the variables.
+type TemplateStep struct {
+	Src     string `yaml:"src"`
+	Content string `yaml:"content"`
+	Dest    string `yaml:"dest"`
+	Mode    string `yaml:"mode"`
+	Backup  bool   `yaml:"backup"`
+}
+
+// ServiceStep brings a systemd unit into State (started, stopped, restarted or reloaded) and,
+// when Enabled is set, enables or disables it.
+type ServiceStep struct {
+	Name    string `yaml:"name"`
+	State   string `yaml:"state"`
+	Enabled *bool  `yaml:"enabled"`
+}
+
+// LineStep is EnsureLine, or EnsureLineAbsent with State "absent".
+type LineStep struct {
+	Path   string `yaml:"path"`
+	Regex  string `yaml:"regex"`
+	Line   string `yaml:"line"`
+	State  string `yaml:"state"`
+	Backup bool   `yaml:"backup"`
+}
+
+// BlockStep is EnsureBlock.
+type BlockStep struct {
+	Path    string `yaml:"path"`
+	Name    string `yaml:"name"`
+	Content string `yaml:"content"`
+	Backup  bool   `yaml:"backup"`
+}
+
+// TaskResult is the outcome of a task, also what Register stores.
+type TaskResult struct {
+	Changed  bool
+	Skipped  bool
+	Failed   bool
+	Msg      string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Diff     string
+}
+
+// TaskReport is a task with its result.
+type TaskReport struct {
+	Name    string
+	Handler bool
+	Result  *TaskResult
+}
+
+// HostReport summarizes a playbook run on one host.
+type HostReport struct {
+	Host    string
+	Tasks   []TaskReport
+	Ok      int
+	Changed int
+	Skipped int
+	Failed  int
+	// Err is the failure that stopped the run, or nil.
+	Err error
+}
+
+// PlaybookRunOption controls Playbook.Run and RunPlaybook.
+type PlaybookRunOption struct {
+	// Check reports what would change without changing anything. Commands are skipped.
+	Check bool
+	// Vars override the playbook variables.
+	Vars map[string]any
+	// Parallel limits the hosts run at once by RunPlaybook (all when <= 0).
+	Parallel int
+}
+
+// LoadPlaybook reads a YAML playbook. Relative local paths in it are resolved against its directory.
+func LoadPlaybook(name string) (*Playbook, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read playbook %s failed", name)
+	}
+	p, err := ParsePlaybook(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse playbook %s failed", name)
+	}
+	p.baseDir = filepath.Dir(name)
+	return p, nil
+}
+
+// ParsePlaybook parses a YAML playbook, rejecting unknown keys.
+func ParsePlaybook(data []byte) (*Playbook, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	p := &Playbook{}
+	if err := dec.Decode(p); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks that every task has exactly one step and that notified handlers exist.
+func (p *Playbook) Validate() error {
+	handlers := make(map[string]bool)
+	for i, h := range p.Handlers {
+		if h.Name == "" {
+			return fmt.Errorf("handler %d has no name", i+1)
+		}
+		if err := h.validate(); err != nil {
+			return fmt.Errorf("handler %s: %w", h.Name, err)
+		}
+		handlers[h.Name] = true
+	}
+	for i, t := range p.Tasks {
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("task %d (%s): %w", i+1, t.Name, err)
+		}
+		for _, n := range t.Notify {
+			if !handlers[n] {
+				return fmt.Errorf("task %d (%s): unknown handler %s", i+1, t.Name, n)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Task) validate() error {
+	n := 0
+	for _, set := range []bool{t.Command != "", t.Upload != nil, t.Template != nil, t.Service != nil, t.Line != nil, t.Block != nil} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("exactly one of command, upload, template, service, line and block is required")
+	}
+	if t.Service != nil {
+		switch t.Service.State {
+		case "", "started", "stopped", "restarted", "reloaded":
+		default:
+			return fmt.Errorf("invalid service state %s", t.Service.State)
+		}
+	}
+	if t.Line != nil && t.Line.State != "" && t.Line.State != "present" && t.Line.State != "absent" {
+		return fmt.Errorf("invalid line state %s", t.Line.State)
+	}
+	return nil
+}
+
+// Run executes the playbook on c. It stops at the first failed task not marked IgnoreErrors;
+// handlers only run when all tasks succeeded. opt may be nil.
+func (p *Playbook) Run(ctx context.Context, c *RichSSHClient, opt *PlaybookRunOption) *HostReport {
+	return p.run(ctx, c, nil, opt)
+}
+
+// RunPlaybook runs p on every host with configured clients, see ForEachHost, and returns the
+// report of each host by name.
+func RunPlaybook(ctx context.Context, p *Playbook, hosts []*InventoryHost, opt *PlaybookRunOption, opts ...RichSSHClientOption) map[string]*HostReport {
+	if opt == nil {
+		opt = &PlaybookRunOption{}
+	}
+	var mu sync.Mutex
+	reports := make(map[string]*HostReport, len(hosts))
+	errs := ForEachHost(ctx, hosts, opt.Parallel, func(ctx context.Context, h *InventoryHost, c *RichSSHClient) error {
+		r := p.run(ctx, c, h.Vars, opt)
+		r.Host = h.Name
+		mu.Lock()
+		reports[h.Name] = r
+		mu.Unlock()
+		return r.Err
+	}, opts...)
+	for name, err := range errs {
+		if _, ok := reports[name]; !ok {
+			reports[name] = &HostReport{Host: name, Err: err}
+		}
+	}
+	return reports
+}
+
+func (p *Playbook) run(ctx context.Context, c *RichSSHClient, hostVars map[string]any, opt *PlaybookRunOption) *HostReport {
+	if opt == nil {
+		opt = &PlaybookRunOption{}
+	}
+	vars := make(map[string]any)
+	for _, m := range []map[string]any{hostVars, p.Vars, opt.Vars} {
+		for k, v := range m {
+			vars[k] = v
+		}
+	}
+
+	report := &HostReport{Host: c.Host}
+	notified := make(map[string]bool)
+	exec := func(t *Task, handler bool) bool {
+		res := p.runTask(ctx, c, t, vars, opt.Check)
+		report.Tasks = append(report.Tasks, TaskReport{Name: t.Name, Handler: handler, Result: res})
+		if t.Register != "" {
+			vars[t.Register] = res
+		}
+		switch {
+		case res.Failed:
+			report.Failed++
+		case res.Skipped:
+			report.Skipped++
+		case res.Changed:
+			report.Changed++
+		default:
+			report.Ok++
+		}
+		if res.Changed {
+			for _, n := range t.Notify {
+				notified[n] = true
+			}
+		}
+		if res.Failed && !t.IgnoreErrors {
+			report.Err = fmt.Errorf("task %s failed: %s", t.Name, res.Msg)
+			return false
+		}
+		return true
+	}
+
+	for _, t := range p.Tasks {
+		if !exec(t, false) {
+			return report
+		}
+	}
+	for _, h := range p.Handlers {
+		if notified[h.Name] && !exec(h, true) {
+			return report
+		}
+	}
+	return report
+}
+
+func (p *Playbook) runTask(ctx context.Context, c *RichSSHClient, t *Task, vars map[string]any, check bool) *TaskResult {
+	if err := ctx.Err(); err != nil {
+		return &TaskResult{Failed: true, Msg: err.Error()}
+	}
+	r := &taskRenderer{vars: vars}
+	if t.When != "" {
+		when := t.When
+		if !strings.Contains(when, "{{") {
+			when = "{{ " + when + " }}"
+		}
+		v := strings.TrimSpace(r.render(when))
+		if r.err != nil {
+			return &TaskResult{Failed: true, Msg: "evaluate when failed: " + r.err.Error()}
+		}
+		switch strings.ToLower(v) {
+		case "", "false", "0", "no", "<no value>": // the last is what index yields for an unset variable
+			return &TaskResult{Skipped: true, Msg: "condition is false"}
+		}
+	}
+
+	res, err := p.runStep(ctx, c, t, r, check)
+	if err == nil {
+		err = r.err
+	}
+	if err != nil {
+		if res == nil {
+			res = &TaskResult{}
+		}
+		res.Failed, res.Msg = true, err.Error()
+	}
+	return res
+}
+
+func (p *Playbook) runStep(ctx context.Context, c *RichSSHClient, t *Task, r *taskRenderer, check bool) (*TaskResult, error) {
+	switch {
+	case t.Command != "":
+		cmd := r.render(t.Command)
+		if r.err != nil {
+			return nil, r.err
+		}
+		if check {
+			return &TaskResult{Skipped: true, Msg: "skipped in check mode"}, nil
+		}
+		resp, err := c.Run(ctx, cmd)
+		if err != nil {
+			return nil, err
+		}
+		res := &TaskResult{Changed: true, ExitCode: resp.ExitCode, Stdout: string(resp.Stdout), Stderr: string(resp.Stderr)}
+		if resp.ExitCode != 0 {
+			return res, fmt.Errorf("exit code %d: %s", resp.ExitCode, strings.TrimSpace(res.Stderr))
+		}
+		return res, nil
+
+	case t.Upload != nil:
+		src, dest, mode := p.localPath(r.render(t.Upload.Src)), r.render(t.Upload.Dest), r.render(t.Upload.Mode)
+		if r.err != nil {
+			return nil, r.err
+		}
+		return uploadIfChanged(ctx, c, src, dest, mode, check)
+
+	case t.Template != nil:
+		tmpl := t.Template.Content
+		if t.Template.Src != "" {
+			b, err := os.ReadFile(p.localPath(r.render(t.Template.Src)))
+			if err != nil {
+				return nil, err
+			}
+			tmpl = string(b)
+		}
+		opt := &FileEditOption{Backup: t.Template.Backup, DryRun: check}
+		if err := parseFileMode(r.render(t.Template.Mode), &opt.Mode); err != nil {
+			return nil, err
+		}
+		dest := r.render(t.Template.Dest)
+		if r.err != nil {
+			return nil, r.err
+		}
+		res, err := fileEditTaskResult(c.RenderTemplate(ctx, dest, tmpl, r.vars, opt))
+		if err != nil || opt.Mode == 0 {
+			return res, err
+		}
+		changed, err := ensureRemoteMode(c, dest, opt.Mode, check)
+		res.Changed = res.Changed || changed
+		return res, err
+
+	case t.Line != nil:
+		path, regex, line := r.render(t.Line.Path), r.render(t.Line.Regex), r.render(t.Line.Line)
+		if r.err != nil {
+			return nil, r.err
+		}
+		opt := &FileEditOption{Backup: t.Line.Backup, DryRun: check}
+		if t.Line.State == "absent" {
+			return fileEditTaskResult(c.EnsureLineAbsent(ctx, path, regex, opt))
+		}
+		return fileEditTaskResult(c.EnsureLine(ctx, path, regex, line, opt))
+
+	case t.Block != nil:
+		path, name, content := r.render(t.Block.Path), r.render(t.Block.Name), r.render(t.Block.Content)
+		if r.err != nil {
+			return nil, r.err
+		}
+		opt := &FileEditOption{Backup: t.Block.Backup, DryRun: check}
+		return fileEditTaskResult(c.EnsureBlock(ctx, path, name, strings.TrimSuffix(content, "\n"), opt))
+
+	default:
+		name := r.render(t.Service.Name)
+		if r.err != nil {
+			return nil, r.err
+		}
+		return serviceTask(ctx, c, name, t.Service, check)
+	}
+}
+
+// localPath resolves a relative local path against the playbook directory.
+func (p *Playbook) localPath(name string) string {
+	if p.baseDir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(p.baseDir, name)
+}
+
+func uploadIfChanged(ctx context.Context, c *RichSSHClient, src, dest, mode string, check bool) (*TaskResult, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err = c.Connect(ctx); err != nil {
+		return nil, err
+	}
+	remoteSum, err := c.remoteSHA256(ctx, dest)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	res := &TaskResult{Changed: remoteSum != hex.EncodeToString(h.Sum(nil))}
+	if res.Changed && !check {
+		if err = c.UploadFile(ctx, src, dest, nil); err != nil {
+			return nil, err
+		}
+	}
+	var m os.FileMode
+	if err = parseFileMode(mode, &m); err != nil || m == 0 {
+		return res, err
+	}
+	changed, err := ensureRemoteMode(c, dest, m, check)
+	res.Changed = res.Changed || changed
+	return res, err
+}
+
+// ensureRemoteMode sets the permission bits of a remote file to mode and reports whether they
+// differed. A file that does not exist yet in check mode is not reported.
+func ensureRemoteMode(c *RichSSHClient, remotePath string, mode os.FileMode, check bool) (bool, error) {
+	if err := c.ensureSFTP(); err != nil {
+		return false, errors.Wrap(err, "sftp unavailable")
+	}
+	fi, err := c.sftpClient.Stat(remotePath)
+	if check && errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "stat %s failed", remotePath)
+	}
+	if fi.Mode().Perm() == mode.Perm() && fi.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) == mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) {
+		return false, nil
+	}
+	if check {
+		return true, nil
+	}
+	if err = c.sftpClient.Chmod(remotePath, mode); err != nil {
+		return false, errors.Wrapf(err, "chmod %s failed", remotePath)
+	}
+	return true, nil
+}
+
+func fileEditTaskResult(res *FileEditResult, err error) (*TaskResult, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &TaskResult{Changed: res.Changed, Diff: res.Diff}, nil
+}
+
+func serviceTask(ctx context.Context, c *RichSSHClient, unit string, s *ServiceStep, check bool) (*TaskResult, error) {
+	res := &TaskResult{}
+	if check {
+		st, err := c.UnitStatus(ctx, unit)
+		if err != nil {
+			return nil, err
+		}
+		if st.LoadState == "not-found" {
+			return nil, fmt.Errorf("unit %s not found", unit)
+		}
+		switch s.State {
+		case "started":
+			res.Changed = !st.Active()
+		case "stopped":
+			res.Changed = st.Active()
+		case "restarted", "reloaded":
+			res.Changed = true
+		}
+		if s.Enabled != nil && *s.Enabled != st.Enabled() {
+			res.Changed = true
+		}
+		return res, nil
+	}
+
+	actions := map[string]func(context.Context, string) (*UnitResult, error){
+		"started":   c.StartUnit,
+		"stopped":   c.StopUnit,
+		"restarted": c.RestartUnit,
+		"reloaded":  c.ReloadUnit,
+	}
+	if s.Enabled != nil {
+		enable := c.DisableUnit
+		if *s.Enabled {
+			enable = c.EnableUnit
+		}
+		ur, err := enable(ctx, unit)
+		if err != nil {
+			return nil, err
+		}
+		res.Changed = ur.Changed
+	}
+	if action, ok := actions[s.State]; ok {
+		ur, err := action(ctx, unit)
+		if err != nil {
+			return nil, err
+		}
+		res.Changed = res.Changed || ur.Changed
+	}
+	return res, nil
+}
+
+func parseFileMode(s string, mode *os.FileMode) error {
+	if s == "" {
+		return nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0o7777 {
+		return fmt.Errorf("invalid file mode %s", s)
+	}
+	// the special bits are separate flags in os.FileMode, as in what Stat reports
+	*mode = os.FileMode(m).Perm()
+	for bit, flag := range map[uint64]os.FileMode{0o4000: os.ModeSetuid, 0o2000: os.ModeSetgid, 0o1000: os.ModeSticky} {
+		if m&bit != 0 {
+			*mode |= flag
+		}
+	}
+	return nil
+}
+
+// taskRenderer renders task strings as templates over vars and keeps the first error.
+type taskRenderer struct {
+	vars map[string]any
+	err  error
+}
+
+func (r *taskRenderer) render(s string) string {
+	if r.err != nil || !strings.Contains(s, "{{") {
+		return s
+	}
+	t, err := template.New("task").Option("missingkey=error").Parse(s)
+	if err != nil {
+		r.err = errors.Wrapf(err, "parse %q failed", s)
+		return ""
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, r.vars); err != nil {
+		r.err = errors.Wrapf(err, "render %q failed", s)
+		return ""
+	}
+	return buf.String()
+}
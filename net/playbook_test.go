@@ -0,0 +1,260 @@
+package net_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+const testPlaybook = `
+name: web
+vars:
+  port: 8080
+tasks:
+  - name: probe
+    command: echo ready
+    register: probe
+  - name: config
+    when: eq .probe.Stdout "ready\n"
+    template:
+      content: "listen {{ .port }}\n"
+      dest: app.conf
+    notify: [reload]
+  - name: never
+    when: "false"
+    command: touch never
+  - name: marker
+    line:
+      path: motd
+      line: "# managed"
+handlers:
+  - name: reload
+    command: echo reloaded >> reloads
+`
+
+func TestPlaybookRun(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	p, err := net.ParsePlaybook([]byte(testPlaybook))
+	require.NoError(t, err)
+
+	// commands are skipped in check mode, and so is what depends on their output
+	r := p.Run(ctx, c, &net.PlaybookRunOption{Check: true})
+	require.NoError(t, r.Err)
+	assert.Equal(t, 3, r.Skipped)
+	assert.Equal(t, 1, r.Changed)
+	assert.NoFileExists(t, filepath.Join(srv.Root, "motd"))
+
+	r = p.Run(ctx, c, nil)
+	require.NoError(t, r.Err)
+	require.Len(t, r.Tasks, 5)
+	assert.True(t, r.Tasks[1].Result.Changed)
+	assert.True(t, r.Tasks[2].Result.Skipped)
+	assert.True(t, r.Tasks[4].Handler)
+	read := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(srv.Root, name))
+		require.NoError(t, err)
+		return string(b)
+	}
+	assert.Equal(t, "listen 8080\n", read("app.conf"))
+	assert.Equal(t, "# managed\n", read("motd"))
+	assert.NoFileExists(t, filepath.Join(srv.Root, "never"))
+
+	// only the command reports a change, so the handler does not run
+	r = p.Run(ctx, c, nil)
+	require.NoError(t, r.Err)
+	assert.Equal(t, 1, r.Changed)
+	assert.Len(t, r.Tasks, 4)
+	assert.Equal(t, "reloaded\n", read("reloads"))
+
+	r = p.Run(ctx, c, &net.PlaybookRunOption{Vars: map[string]any{"port": 9090}})
+	require.NoError(t, r.Err)
+	assert.Equal(t, "listen 9090\n", read("app.conf"))
+	assert.Equal(t, "reloaded\nreloaded\n", read("reloads"))
+}
+
+func TestPlaybookFailure(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+
+	p, err := net.ParsePlaybook([]byte(`
+tasks:
+  - name: tolerated
+    command: exit 3
+    ignore_errors: true
+  - name: broken
+    command: echo oops >&2; exit 1
+  - name: after
+    command: touch after
+`))
+	require.NoError(t, err)
+	r := p.Run(context.Background(), c, nil)
+	require.Error(t, r.Err)
+	assert.Contains(t, r.Err.Error(), "oops")
+	assert.Equal(t, 2, r.Failed)
+	assert.Equal(t, 3, r.Tasks[0].Result.ExitCode)
+	assert.NoFileExists(t, filepath.Join(srv.Root, "after"))
+}
+
+func TestPlaybookUpload(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.bin"), []byte("binary"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "site.yml"), []byte(`
+tasks:
+  - name: upload
+    upload: {src: app.bin, dest: app.bin, mode: "0750"}
+`), 0o600))
+	p, err := net.LoadPlaybook(filepath.Join(dir, "site.yml"))
+	require.NoError(t, err)
+
+	r := p.Run(ctx, c, nil)
+	require.NoError(t, r.Err)
+	assert.Equal(t, 1, r.Changed)
+	fi, err := os.Stat(filepath.Join(srv.Root, "app.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o750), fi.Mode().Perm())
+
+	r = p.Run(ctx, c, nil)
+	require.NoError(t, r.Err)
+	assert.Equal(t, 1, r.Ok)
+
+	// same content with the wrong mode is fixed too
+	require.NoError(t, os.Chmod(filepath.Join(srv.Root, "app.bin"), 0o600))
+	r = p.Run(ctx, c, &net.PlaybookRunOption{Check: true})
+	require.NoError(t, r.Err)
+	assert.Equal(t, 1, r.Changed)
+	r = p.Run(ctx, c, nil)
+	require.NoError(t, r.Err)
+	assert.Equal(t, 1, r.Changed)
+	fi, err = os.Stat(filepath.Join(srv.Root, "app.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o750), fi.Mode().Perm())
+}
+
+func TestPlaybookTemplateMode(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	name := filepath.Join(srv.Root, "app.conf")
+	require.NoError(t, os.WriteFile(name, []byte("port 80\n"), 0o644))
+	p, err := net.ParsePlaybook([]byte(`
+tasks:
+  - name: config
+    template: {content: "port {{ .port }}\n", dest: app.conf, mode: "0600"}
+`))
+	require.NoError(t, err)
+	r := p.Run(ctx, c, &net.PlaybookRunOption{Vars: map[string]any{"port": 80}})
+	require.NoError(t, r.Err)
+	assert.Equal(t, 1, r.Changed)
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+}
+
+func TestPlaybookSpecialModeBits(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.bin"), []byte("binary"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "site.yml"), []byte(`
+tasks:
+  - name: upload
+    upload: {src: app.bin, dest: app.bin, mode: "4755"}
+    notify: [reload]
+  - name: config
+    template: {content: "x\n", dest: app.conf, mode: "2750"}
+    notify: [reload]
+handlers:
+  - name: reload
+    command: echo reloaded >> reloads
+`), 0o600))
+	p, err := net.LoadPlaybook(filepath.Join(dir, "site.yml"))
+	require.NoError(t, err)
+
+	r := p.Run(ctx, c, nil)
+	require.NoError(t, r.Err)
+	assert.Equal(t, 3, r.Changed, "both tasks and the handler")
+	fi, err := os.Stat(filepath.Join(srv.Root, "app.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSetuid|0o755, fi.Mode()&(os.ModeSetuid|os.ModePerm))
+	fi, err = os.Stat(filepath.Join(srv.Root, "app.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSetgid|0o750, fi.Mode()&(os.ModeSetgid|os.ModePerm))
+
+	// a second run changes nothing and does not notify again
+	r = p.Run(ctx, c, nil)
+	require.NoError(t, r.Err)
+	assert.Equal(t, 0, r.Changed)
+	assert.Equal(t, 2, r.Ok)
+	b, err := os.ReadFile(filepath.Join(srv.Root, "reloads"))
+	require.NoError(t, err)
+	assert.Equal(t, "reloaded\n", string(b))
+}
+
+func TestPlaybookUndefinedVariable(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	p, err := net.ParsePlaybook([]byte(`
+vars:
+  app: web
+tasks:
+  - name: cleanup
+    command: touch "{{ .ap }}"
+`))
+	require.NoError(t, err)
+	r := p.Run(ctx, c, nil)
+	require.Error(t, r.Err)
+	assert.Contains(t, r.Err.Error(), "ap")
+	entries, err := os.ReadDir(srv.Root)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	p, err = net.ParsePlaybook([]byte(`
+tasks:
+  - name: typo
+    when: .enabled
+    command: touch typo
+  - name: optional
+    when: index . "enabled"
+    command: touch optional
+`))
+	require.NoError(t, err)
+	r = p.Run(ctx, c, nil)
+	require.Error(t, r.Err)
+	assert.Contains(t, r.Tasks[0].Result.Msg, "evaluate when failed")
+
+	p.Tasks = p.Tasks[1:]
+	r = p.Run(ctx, c, nil)
+	require.NoError(t, r.Err)
+	assert.Equal(t, 1, r.Skipped)
+}
+
+func TestParsePlaybookInvalid(t *testing.T) {
+	for _, data := range []string{
+		"tasks:\n  - name: a\n",
+		"tasks:\n  - name: a\n    command: ls\n    upload: {src: a, dest: b}\n",
+		"tasks:\n  - name: a\n    command: ls\n    notify: [missing]\n",
+		"tasks:\n  - name: a\n    comand: ls\n",
+		"tasks:\n  - name: a\n    service: {name: x, state: paused}\n",
+	} {
+		_, err := net.ParsePlaybook([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
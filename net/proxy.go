@@ -0,0 +1,288 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// ProxyDialer dials through a chain of proxies (http, https, socks5, socks5h, with user:password auth).
+// Its DialContext fits http.Transport.DialContext, database drivers and both SSH clients.
+// With no proxies it dials directly, or through ALL_PROXY (honoring NO_PROXY) when UseEnvironment is set.
+type ProxyDialer struct {
+	// Proxies are the hops in order: the first is dialed directly, each next one through the previous.
+	Proxies []*url.URL
+	// Timeout bounds the TCP dial and each proxy negotiation when > 0.
+	Timeout time.Duration
+	// TLSConfig is used for https:// proxies; ServerName defaults to the proxy host.
+	TLSConfig *tls.Config
+	// UseEnvironment falls back to ALL_PROXY/NO_PROXY when Proxies is empty.
+	UseEnvironment bool
+}
+
+// NewProxyDialer parses the proxy URLs into a chain. Empty strings are skipped.
+func NewProxyDialer(proxyURLs ...string) (*ProxyDialer, error) {
+	d := &ProxyDialer{}
+	for _, raw := range proxyURLs {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid proxy url %s", raw)
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https", "socks5", "socks5h", "socks":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %s", u.Scheme)
+		}
+		d.Proxies = append(d.Proxies, u)
+	}
+	return d, nil
+}
+
+// DialContext connects to addr through the proxy chain.
+func (d *ProxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	base := &net.Dialer{Timeout: d.Timeout}
+	proxies := d.Proxies
+	if len(proxies) == 0 && d.UseEnvironment {
+		u, err := proxyFromEnvironment(addr)
+		if err != nil {
+			return nil, err
+		}
+		if u != nil {
+			proxies = []*url.URL{u}
+		}
+	}
+
+	dial := dialContextFunc(base.DialContext)
+	for _, u := range proxies {
+		forward, hop := dial, u
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialViaProxy(ctx, forward, hop, network, addr, d.TLSConfig, d.Timeout)
+		}
+	}
+	return dial(ctx, network, addr)
+}
+
+// Dial connects to addr through the proxy chain. It implements golang.org/x/net/proxy.Dialer.
+func (d *ProxyDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// dialContextFunc matches net.Dialer.DialContext.
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Dial lets dialContextFunc act as the forward dialer of a SOCKS5 proxy.
+func (f dialContextFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(context.Background(), network, addr)
+}
+
+func (f dialContextFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// proxyFromEnvironment returns the proxy from ALL_PROXY for addr, or nil when it is unset or addr
+// matches NO_PROXY (the same rules as net/http, including the implicit localhost exclusion).
+func proxyFromEnvironment(addr string) (*url.URL, error) {
+	raw := getenvAny("ALL_PROXY", "all_proxy")
+	if raw == "" {
+		return nil, nil
+	}
+	// httpproxy only understands http(s) proxies, so it is used for the NO_PROXY match alone.
+	cfg := httpproxy.Config{HTTPSProxy: "http://proxy.invalid", NoProxy: getenvAny("NO_PROXY", "no_proxy")}
+	matched, err := cfg.ProxyFunc()(&url.URL{Scheme: "https", Host: addr})
+	if err != nil || matched == nil {
+		return nil, err
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid ALL_PROXY %s", raw)
+	}
+	return u, nil
+}
+
+func getenvAny(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// dialViaProxy connects to addr through the proxy u using forward to reach the proxy.
+// timeout bounds the proxy negotiation (SOCKS5 handshake, CONNECT exchange, TLS handshake) when > 0.
+func dialViaProxy(ctx context.Context, forward dialContextFunc, u *url.URL, network, addr string, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	switch strings.ToLower(u.Scheme) {
+	case "socks5", "socks5h", "socks":
+		return dialSOCKS5(ctx, forward, u, network, addr, timeout)
+	case "http", "https":
+		return dialHTTPConnect(ctx, forward, u, addr, tlsConfig, timeout)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %s", u.Scheme)
+	}
+}
+
+// dialSOCKS5 dials addr through a SOCKS5 proxy. With socks5:// the target host is resolved locally,
+// with socks5h:// (and socks://) by the proxy. timeout bounds the resolution and the handshake when > 0.
+func dialSOCKS5(ctx context.Context, forward dialContextFunc, u *url.URL, network, addr string, timeout time.Duration) (net.Conn, error) {
+	var auth *proxy.Auth
+	if u.User != nil {
+		pw, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: pw}
+	}
+
+	conn, err := forward(ctx, "tcp", hostPortDefault(u, "1080"))
+	if err != nil {
+		return nil, err
+	}
+	hctx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		hctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if strings.ToLower(u.Scheme) == "socks5" {
+		host, port, err1 := net.SplitHostPort(addr)
+		if err1 != nil {
+			_ = conn.Close()
+			return nil, err1
+		}
+		if net.ParseIP(host) == nil {
+			ips, err2 := net.DefaultResolver.LookupHost(hctx, host)
+			if err2 != nil {
+				_ = conn.Close()
+				return nil, err2
+			}
+			addr = net.JoinHostPort(ips[0], port)
+		}
+	}
+
+	// the handshake runs on the connection dialed above, under the deadline of hctx
+	dialer, err := proxy.SOCKS5("tcp", hostPortDefault(u, "1080"), auth, dialContextFunc(func(context.Context, string, string) (net.Conn, error) {
+		return conn, nil
+	}))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	c, err := dialer.(proxy.ContextDialer).DialContext(hctx, network, addr)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "socks5 handshake failed")
+	}
+	return c, nil
+}
+
+// dialHTTPConnect opens a tunnel to addr with an HTTP CONNECT request. https:// proxies are spoken to over TLS.
+func dialHTTPConnect(ctx context.Context, forward dialContextFunc, u *url.URL, addr string, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	isTLS := strings.ToLower(u.Scheme) == "https"
+	defaultPort := "80"
+	if isTLS {
+		defaultPort = "443"
+	}
+
+	conn, err := forward(ctx, "tcp", hostPortDefault(u, defaultPort))
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+
+	fail := func(err error) (net.Conn, error) {
+		_ = conn.Close()
+		if !stop() {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	if isTLS {
+		cfg := &tls.Config{}
+		if tlsConfig != nil {
+			cfg = tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			return fail(errors.Wrap(err, "proxy tls handshake failed"))
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		pw, _ := u.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+basicAuth(u.User.Username(), pw))
+	}
+	if err = req.Write(conn); err != nil {
+		return fail(errors.Wrap(err, "write proxy connect request failed"))
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return fail(errors.Wrap(err, "read proxy connect response failed"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return fail(fmt.Errorf("proxy connect failed: %s", resp.Status))
+	}
+
+	if !stop() {
+		_ = conn.Close()
+		return nil, ctx.Err()
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	if br.Buffered() > 0 {
+		// the server may already have sent data (e.g. the SSH banner) behind the response
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn serves bytes already read into r before reading from Conn.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func hostPortDefault(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
@@ -0,0 +1,96 @@
+package net
+
+import (
+	"context"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/designinlife/slib/errors"
+	"github.com/designinlife/slib/shell"
+)
+
+// ProxyCommandDialer returns a dial function that, like OpenSSH's ProxyCommand, runs command locally
+// and speaks over its stdin/stdout. %h, %p and %r in command are replaced with the target host, port
+// and user, %% with a literal %. The process is killed when the connection is closed.
+func ProxyCommandDialer(command, user string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		expanded := strings.NewReplacer("%%", "%", "%h", host, "%p", port, "%r", user).Replace(command)
+
+		// not CommandContext: the process must outlive the dial context
+		cmd := exec.Command(shell.CommandName, shell.CrossbarArg, expanded)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err = cmd.Start(); err != nil {
+			return nil, errors.Wrapf(err, "start proxy command %q failed", expanded)
+		}
+		if err = ctx.Err(); err != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return nil, err
+		}
+		return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout, addr: commandAddr(expanded)}, nil
+	}
+}
+
+// commandConn is a net.Conn over a subprocess' stdin/stdout. Deadlines are not supported.
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	addr   commandAddr
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (c *commandConn) Read(b []byte) (int, error) {
+	return c.stdout.Read(b)
+}
+
+func (c *commandConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.stdin.Close()
+		_ = c.cmd.Process.Kill()
+		_ = c.cmd.Wait()
+	})
+	return c.closeErr
+}
+
+func (c *commandConn) LocalAddr() net.Addr  { return c.addr }
+func (c *commandConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *commandConn) SetDeadline(time.Time) error {
+	return errors.New("proxy command connection: deadline not supported")
+}
+
+func (c *commandConn) SetReadDeadline(time.Time) error {
+	return errors.New("proxy command connection: deadline not supported")
+}
+
+func (c *commandConn) SetWriteDeadline(time.Time) error {
+	return errors.New("proxy command connection: deadline not supported")
+}
+
+// commandAddr names the proxy command as the connection address.
+type commandAddr string
+
+func (a commandAddr) Network() string { return "proxycommand" }
+func (a commandAddr) String() string  { return string(a) }
@@ -0,0 +1,47 @@
+package net_test
+
+import (
+	"context"
+	stdnet "net"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+func TestRichSSHClientWithConn(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv, net.WithConn(srv.DialPipe()))
+	resp, err := c.Run(context.Background(), "echo piped")
+	require.NoError(t, err)
+	assert.Equal(t, "piped\n", string(resp.Stdout))
+}
+
+func TestRichSSHClientWithDialContext(t *testing.T) {
+	srv := newTestServer(t)
+	var dialed []string
+	c := newTestClient(t, srv, net.WithDialContext(func(ctx context.Context, network, addr string) (stdnet.Conn, error) {
+		dialed = append(dialed, addr)
+		return srv.DialPipe(), nil
+	}))
+
+	resp, err := c.Run(context.Background(), "echo custom")
+	require.NoError(t, err)
+	assert.Equal(t, "custom\n", string(resp.Stdout))
+	assert.Equal(t, []string{srv.Addr}, dialed)
+}
+
+func TestRichSSHClientWithProxyCommand(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	srv := newTestServer(t)
+	c := newTestClient(t, srv, net.WithProxyCommand("exec 3<>/dev/tcp/%h/%p; cat <&3 & exec cat >&3"))
+
+	resp, err := c.Run(context.Background(), "echo via-command")
+	require.NoError(t, err)
+	assert.Equal(t, "via-command\n", string(resp.Stdout))
+}
@@ -0,0 +1,324 @@
+package net_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/pem"
+	"io"
+	stdnet "net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+// connectProxy is an HTTP CONNECT proxy that sends the target's first bytes together with
+// the 200 response, as real proxies may do.
+func connectProxy(t *testing.T, user, password string) *httptest.Server {
+	return httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "connect only", http.StatusMethodNotAllowed)
+			return
+		}
+		if u, p, ok := parseProxyAuth(r); user != "" && (!ok || u != user || p != password) {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="test"`)
+			http.Error(w, "auth required", http.StatusProxyAuthRequired)
+			return
+		}
+		target, err := stdnet.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		banner := make([]byte, 8)
+		_ = target.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _ := io.ReadFull(target, banner)
+		_ = target.SetReadDeadline(time.Time{})
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+		_, _ = rw.Write(banner[:n])
+		_ = rw.Flush()
+
+		go func() { _, _ = io.Copy(target, rw) }()
+		_, _ = io.Copy(conn, target)
+	}))
+}
+
+func parseProxyAuth(r *http.Request) (string, string, bool) {
+	r2 := &http.Request{Header: http.Header{"Authorization": r.Header.Values("Proxy-Authorization")}}
+	return r2.BasicAuth()
+}
+
+func TestRichSSHClientHTTPProxy(t *testing.T) {
+	srv := newTestServer(t)
+	px := connectProxy(t, "pu", "pp")
+	px.Start()
+	defer px.Close()
+
+	c := newTestClient(t, srv, net.WithProxyURL("http://pu:pp@"+px.Listener.Addr().String()))
+	resp, err := c.Run(context.Background(), "echo proxied")
+	require.NoError(t, err)
+	assert.Equal(t, "proxied\n", string(resp.Stdout))
+
+	bad := net.NewRichSSHClient(srv.Host, srv.Port, "tester", net.WithPassword("secret"), net.WithProxyURL("http://"+px.Listener.Addr().String()))
+	defer bad.Close()
+	err = bad.Connect(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "407")
+}
+
+func TestRichSSHClientHTTPSProxy(t *testing.T) {
+	srv := newTestServer(t)
+	px := connectProxy(t, "", "")
+	px.StartTLS()
+	defer px.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: px.Certificate().Raw})
+	c := newTestClient(t, srv, net.WithProxyURL("https://"+px.Listener.Addr().String()), net.WithProxyCA(ca))
+	resp, err := c.Run(context.Background(), "echo tls-proxied")
+	require.NoError(t, err)
+	assert.Equal(t, "tls-proxied\n", string(resp.Stdout))
+
+	untrusted := net.NewRichSSHClient(srv.Host, srv.Port, "tester", net.WithPassword("secret"), net.WithProxyURL("https://"+px.Listener.Addr().String()))
+	defer untrusted.Close()
+	assert.Error(t, untrusted.Connect(context.Background()))
+}
+
+func TestProxyDialerChain(t *testing.T) {
+	srv := newTestServer(t)
+	first := connectProxy(t, "", "")
+	first.Start()
+	defer first.Close()
+	second := connectProxy(t, "u", "p")
+	second.Start()
+	defer second.Close()
+
+	d, err := net.NewProxyDialer("http://"+first.Listener.Addr().String(), "http://u:p@"+second.Listener.Addr().String())
+	require.NoError(t, err)
+	require.Len(t, d.Proxies, 2)
+
+	conn, err := d.DialContext(context.Background(), "tcp", srv.Addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	banner := make([]byte, 4)
+	_, err = io.ReadFull(conn, banner)
+	require.NoError(t, err)
+	assert.Equal(t, "SSH-", string(banner))
+
+	_, err = net.NewProxyDialer("ftp://proxy")
+	assert.Error(t, err)
+}
+
+// socksProxy is a minimal SOCKS5 proxy. Requests for names go to resolve(name); every requested
+// target ("host:port" as sent by the client) is recorded.
+type socksProxy struct {
+	stdnet.Listener
+	user, password string
+	resolve        func(host string) string
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func newSocksProxy(t *testing.T, user, password string, resolve func(string) string) *socksProxy {
+	l, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	p := &socksProxy{Listener: l, user: user, password: password, resolve: resolve}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err1 := l.Accept()
+			if err1 != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *socksProxy) Targets() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+func (p *socksProxy) serve(conn stdnet.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil
+		}
+		return b
+	}
+
+	hdr := read(2)
+	if hdr == nil || hdr[0] != 5 || read(int(hdr[1])) == nil {
+		return
+	}
+	if p.user != "" {
+		_, _ = conn.Write([]byte{5, 2})
+		ver := read(1)
+		ulen := read(1)
+		if ver == nil || ulen == nil {
+			return
+		}
+		u := read(int(ulen[0]))
+		plen := read(1)
+		if u == nil || plen == nil {
+			return
+		}
+		pw := read(int(plen[0]))
+		if string(u) != p.user || string(pw) != p.password {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+	} else {
+		_, _ = conn.Write([]byte{5, 0})
+	}
+
+	req := read(4)
+	if req == nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		host = stdnet.IP(read(4)).String()
+	case 3:
+		n := read(1)
+		if n == nil {
+			return
+		}
+		host = string(read(int(n[0])))
+	case 4:
+		host = stdnet.IP(read(16)).String()
+	}
+	port := read(2)
+	if port == nil {
+		return
+	}
+	target := stdnet.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+	p.mu.Lock()
+	p.targets = append(p.targets, target)
+	p.mu.Unlock()
+
+	dial := target
+	if stdnet.ParseIP(host) == nil && p.resolve != nil {
+		dial = p.resolve(host)
+	}
+	upstream, err := stdnet.Dial("tcp", dial)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go func() { _, _ = io.Copy(upstream, r) }()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func TestRichSSHClientSOCKS5Proxy(t *testing.T) {
+	srv := newTestServer(t)
+	px := newSocksProxy(t, "su", "sp", func(string) string { return srv.Addr })
+
+	// socks5h hands the name to the proxy, socks5 resolves it locally
+	c := net.NewRichSSHClient("localhost", srv.Port, "tester", net.WithPassword("secret"), net.WithProxyURL("socks5h://su:sp@"+px.Addr().String()))
+	defer c.Close()
+	resp, err := c.Run(context.Background(), "echo socks")
+	require.NoError(t, err)
+	assert.Equal(t, "socks\n", string(resp.Stdout))
+	assert.Equal(t, []string{"localhost:" + strconv.Itoa(srv.Port)}, px.Targets())
+
+	c2 := net.NewRichSSHClient("localhost", srv.Port, "tester", net.WithPassword("secret"), net.WithProxyURL("socks5://su:sp@"+px.Addr().String()))
+	defer c2.Close()
+	require.NoError(t, c2.Connect(context.Background()))
+	targets := px.Targets()
+	require.Len(t, targets, 2)
+	host, _, err := stdnet.SplitHostPort(targets[1])
+	require.NoError(t, err)
+	assert.NotNil(t, stdnet.ParseIP(host), "socks5 sends an address, got %s", targets[1])
+
+	bad := net.NewRichSSHClient(srv.Host, srv.Port, "tester", net.WithPassword("secret"), net.WithProxyURL("socks5://su:wrong@"+px.Addr().String()))
+	defer bad.Close()
+	assert.Error(t, bad.Connect(context.Background()))
+}
+
+func TestProxyDialerSOCKS5Timeout(t *testing.T) {
+	// a proxy that accepts connections but never answers the greeting
+	l, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	conns := make(chan stdnet.Conn, 1)
+	go func() {
+		for {
+			conn, err1 := l.Accept()
+			if err1 != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	defer func() {
+		select {
+		case conn := <-conns:
+			_ = conn.Close()
+		default:
+		}
+	}()
+
+	d, err := net.NewProxyDialer("socks5h://" + l.Addr().String())
+	require.NoError(t, err)
+	d.Timeout = 200 * time.Millisecond
+	start := time.Now()
+	_, err = d.DialContext(context.Background(), "tcp", "example.com:22")
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestProxyDialerEnvironment(t *testing.T) {
+	srv := newTestServer(t)
+	px := newSocksProxy(t, "", "", func(string) string { return srv.Addr })
+	t.Setenv("ALL_PROXY", "socks5h://"+px.Addr().String())
+	t.Setenv("NO_PROXY", "direct.test")
+
+	d := &net.ProxyDialer{UseEnvironment: true, Timeout: 2 * time.Second}
+	conn, err := d.DialContext(context.Background(), "tcp", "ssh.example.test:22")
+	require.NoError(t, err)
+	banner := make([]byte, 4)
+	_, err = io.ReadFull(conn, banner)
+	require.NoError(t, err)
+	assert.Equal(t, "SSH-", string(banner))
+	_ = conn.Close()
+	assert.Equal(t, []string{"ssh.example.test:22"}, px.Targets())
+
+	// NO_PROXY hosts and localhost are dialed directly
+	_, err = d.DialContext(context.Background(), "tcp", "host.direct.test:22")
+	assert.Error(t, err)
+	conn, err = d.DialContext(context.Background(), "tcp", srv.Addr)
+	require.NoError(t, err)
+	_ = conn.Close()
+	assert.Len(t, px.Targets(), 1)
+
+	// without UseEnvironment, ALL_PROXY is ignored
+	_, err = (&net.ProxyDialer{Timeout: 2 * time.Second}).DialContext(context.Background(), "tcp", "ssh.example.test:22")
+	assert.Error(t, err)
+	assert.Len(t, px.Targets(), 1)
+}
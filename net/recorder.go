@@ -0,0 +1,286 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// Recorder writes RunStream and Shell sessions as asciicast v2 files (https://docs.asciinema.org),
+// one file per session in Dir.
+type Recorder struct {
+	Dir    string
+	Width  int               // terminal width in the header (default 80)
+	Height int               // terminal height in the header (default 24)
+	Env    map[string]string // header env (default TERM=xterm, SHELL=/bin/sh)
+	// RecordInput also records "i" events for data sent to interactive sessions.
+	RecordInput bool
+	// MaxFiles keeps only the newest MaxFiles recordings in Dir; 0 keeps all. Only files named
+	// like the recorder names them ("host-20060102T150405-1.cast") are removed, and never while
+	// their session is still open.
+	MaxFiles int
+
+	seq  atomic.Uint64
+	mu   sync.Mutex
+	live map[string]struct{} // files of open sessions
+}
+
+// NewRecorder creates a recorder writing into dir.
+func NewRecorder(dir string) *Recorder {
+	return &Recorder{Dir: dir, Width: 80, Height: 24}
+}
+
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// castSession is one open recording.
+type castSession struct {
+	mu    sync.Mutex
+	r     *Recorder
+	path  string
+	f     *os.File
+	w     *bufio.Writer
+	start time.Time
+	// incomplete UTF-8 tails per event type, carried over to the next write
+	pending map[string][]byte
+}
+
+// start opens a new recording file for a session on host.
+func (r *Recorder) start(host, command string) (*castSession, error) {
+	if err := os.MkdirAll(r.Dir, 0o750); err != nil {
+		return nil, errors.Wrapf(err, "create recording directory %s failed", r.Dir)
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s-%d.cast", strings.ReplaceAll(host, ":", "_"), now.Format("20060102T150405"), r.seq.Add(1))
+	p := filepath.Join(r.Dir, name)
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, errors.Wrap(err, "create recording file failed")
+	}
+
+	h := castHeader{
+		Version:   2,
+		Width:     r.Width,
+		Height:    r.Height,
+		Timestamp: now.Unix(),
+		Command:   command,
+		Title:     host,
+		Env:       r.Env,
+	}
+	if h.Width <= 0 {
+		h.Width = 80
+	}
+	if h.Height <= 0 {
+		h.Height = 24
+	}
+	if h.Env == nil {
+		h.Env = map[string]string{"TERM": "xterm", "SHELL": "/bin/sh"}
+	}
+
+	s := &castSession{r: r, path: p, f: f, w: bufio.NewWriter(f), start: now, pending: make(map[string][]byte)}
+	if err = json.NewEncoder(s.w).Encode(h); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "write recording header failed")
+	}
+
+	r.mu.Lock()
+	if r.live == nil {
+		r.live = make(map[string]struct{})
+	}
+	r.live[p] = struct{}{}
+	r.mu.Unlock()
+	r.rotate()
+	return s, nil
+}
+
+// castFileRe matches the names of the files start creates.
+var castFileRe = regexp.MustCompile(`^.+-[0-9]{8}T[0-9]{6}-[0-9]+\.cast$`)
+
+// rotate removes the oldest recordings beyond MaxFiles, except those of open sessions, which are
+// removed by a later rotation once closed.
+func (r *Recorder) rotate() {
+	if r.MaxFiles <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	matches, err := filepath.Glob(filepath.Join(r.Dir, "*.cast"))
+	if err != nil {
+		return
+	}
+	files := matches[:0]
+	for _, p := range matches {
+		if castFileRe.MatchString(filepath.Base(p)) {
+			files = append(files, p)
+		}
+	}
+	if len(files) <= r.MaxFiles {
+		return
+	}
+	type entry struct {
+		path string
+		mod  time.Time
+	}
+	entries := make([]entry, 0, len(files))
+	for _, p := range files {
+		if fi, err1 := os.Stat(p); err1 == nil {
+			entries = append(entries, entry{p, fi.ModTime()})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].mod.Before(entries[j].mod) })
+	for i := 0; i < len(entries)-r.MaxFiles; i++ {
+		if _, ok := r.live[entries[i].path]; !ok {
+			_ = os.Remove(entries[i].path)
+		}
+	}
+}
+
+// event appends an event of type kind ("o" or "i").
+func (s *castSession) event(kind string, b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := append(s.pending[kind], b...)
+	data, s.pending[kind] = splitIncompleteUTF8(data)
+	if len(data) == 0 {
+		return
+	}
+	line, err := json.Marshal([]any{time.Since(s.start).Seconds(), kind, string(data)})
+	if err != nil {
+		return
+	}
+	_, _ = s.w.Write(line)
+	_ = s.w.WriteByte('\n')
+}
+
+func (s *castSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.w.Flush()
+	if err1 := s.f.Close(); err == nil {
+		err = err1
+	}
+
+	s.r.mu.Lock()
+	_, live := s.r.live[s.path]
+	delete(s.r.live, s.path)
+	s.r.mu.Unlock()
+	if live {
+		s.r.rotate()
+	}
+	return err
+}
+
+// writer returns a writer recording everything written to w as kind events. w may be nil.
+func (s *castSession) writer(kind string, w io.Writer) io.Writer {
+	return &castWriter{s: s, kind: kind, w: w}
+}
+
+type castWriter struct {
+	s    *castSession
+	kind string
+	w    io.Writer
+}
+
+func (cw *castWriter) Write(b []byte) (int, error) {
+	cw.s.event(cw.kind, b)
+	if cw.w == nil {
+		return len(b), nil
+	}
+	return cw.w.Write(b)
+}
+
+// splitIncompleteUTF8 splits off a trailing incomplete UTF-8 sequence so events hold whole characters.
+func splitIncompleteUTF8(b []byte) ([]byte, []byte) {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i], append([]byte(nil), b[i:]...)
+			}
+			break
+		}
+	}
+	return b, nil
+}
+
+// PlayCast replays the output events of an asciicast v2 recording to w.
+// speed scales playback (2 is twice as fast; <= 0 means 1); idle pauses are capped at maxIdle when > 0.
+func PlayCast(ctx context.Context, r io.Reader, w io.Writer, speed float64, maxIdle time.Duration) error {
+	if speed <= 0 {
+		speed = 1
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return errors.New("empty recording")
+	}
+	var h castHeader
+	if err := json.Unmarshal(scanner.Bytes(), &h); err != nil {
+		return errors.Wrap(err, "parse recording header failed")
+	}
+	if h.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version %d", h.Version)
+	}
+
+	var last float64
+	for scanner.Scan() {
+		var ev []json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil || len(ev) < 3 {
+			return fmt.Errorf("invalid recording event: %s", scanner.Text())
+		}
+		var (
+			ts   float64
+			kind string
+			data string
+		)
+		if json.Unmarshal(ev[0], &ts) != nil || json.Unmarshal(ev[1], &kind) != nil || json.Unmarshal(ev[2], &data) != nil {
+			return fmt.Errorf("invalid recording event: %s", scanner.Text())
+		}
+		if kind != "o" {
+			continue
+		}
+
+		delay := time.Duration((ts - last) / speed * float64(time.Second))
+		if maxIdle > 0 && delay > maxIdle {
+			delay = maxIdle
+		}
+		last = ts
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
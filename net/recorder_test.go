@@ -0,0 +1,87 @@
+package net_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+func TestRecorder(t *testing.T) {
+	srv := newTestServer(t)
+	rec := net.NewRecorder(t.TempDir())
+	rec.RecordInput = true
+	rec.MaxFiles = 1
+	// recordings of others in the same directory are left alone
+	foreign := filepath.Join(rec.Dir, "demo.cast")
+	require.NoError(t, os.WriteFile(foreign, []byte("{}\n"), 0o644))
+	c := newTestClient(t, srv, net.WithRecorder(rec))
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	require.NoError(t, c.RunStream(ctx, "printf 'héllo\\n'", &out, nil))
+	assert.Equal(t, "héllo\n", out.String())
+
+	out.Reset()
+	require.NoError(t, c.Shell(ctx, strings.NewReader("echo from-shell\nexit\n"), &out, &out))
+	assert.Equal(t, "from-shell\n", out.String())
+
+	assert.FileExists(t, foreign)
+	require.NoError(t, os.Remove(foreign))
+	files, err := filepath.Glob(filepath.Join(rec.Dir, "*.cast"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	b, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"version":2`)
+	assert.Contains(t, string(b), `"i","echo from-shell\nexit\n"`)
+
+	var replay bytes.Buffer
+	require.NoError(t, net.PlayCast(ctx, bytes.NewReader(b), &replay, 100, 0))
+	assert.Equal(t, "from-shell\n", replay.String())
+}
+
+func TestRecorderKeepsOpenSessions(t *testing.T) {
+	srv := newTestServer(t)
+	rec := net.NewRecorder(t.TempDir())
+	rec.MaxFiles = 1
+	c := newTestClient(t, srv, net.WithRecorder(rec))
+	ctx := context.Background()
+
+	// an idle shell stays open while other sessions are recorded
+	stdin, input := io.Pipe()
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- c.Shell(ctx, stdin, &out, &out) }()
+	casts := func() []string {
+		files, err := filepath.Glob(filepath.Join(rec.Dir, "*.cast"))
+		require.NoError(t, err)
+		return files
+	}
+	require.Eventually(t, func() bool { return len(casts()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	shellCast := casts()[0]
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, c.RunStream(ctx, "echo short", io.Discard, nil))
+	}
+	assert.FileExists(t, shellCast)
+	assert.Len(t, casts(), 2)
+
+	time.Sleep(50 * time.Millisecond) // file times may be coarse
+	_, err := io.WriteString(input, "echo from-idle\nexit\n")
+	require.NoError(t, err)
+	require.NoError(t, input.Close())
+	require.NoError(t, <-done)
+	// the shell is now the newest recording and the only one kept
+	assert.Equal(t, []string{shellCast}, casts())
+}
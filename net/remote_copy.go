@@ -0,0 +1,210 @@
+package net
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/sftp"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// RemoteCopyOption controls CopyRemoteToRemote.
+type RemoteCopyOption struct {
+	// Progress receives "copied/total" lines per file, like UploadFile's progressWriter.
+	Progress io.Writer
+	// Verify compares the SHA-256 of source and destination after each file is copied.
+	Verify bool
+	// Resume continues a partially copied destination file instead of starting over. A destination
+	// that is not a prefix of the source (e.g. a stale file of the same size) is only noticed with
+	// Verify, which then copies the file again from the start.
+	Resume bool
+	// Retries is the number of extra attempts per file after a failed transfer.
+	Retries int
+}
+
+// CopyRemoteToRemote streams srcPath on src into dstPath on dst through memory only, over the SFTP sessions
+// of both clients. Directories are copied recursively. opt may be nil.
+func CopyRemoteToRemote(ctx context.Context, src *RichSSHClient, srcPath string, dst *RichSSHClient, dstPath string, opt *RemoteCopyOption) error {
+	if opt == nil {
+		opt = &RemoteCopyOption{}
+	}
+	for _, c := range []*RichSSHClient{src, dst} {
+		if err := c.Connect(ctx); err != nil {
+			return err
+		}
+		if err := c.ensureSFTP(); err != nil {
+			return errors.Wrapf(err, "sftp unavailable on %s", c.Host)
+		}
+	}
+
+	fi, err := src.sftpClient.Stat(srcPath)
+	if err != nil {
+		return errors.Wrapf(err, "stat %s:%s failed", src.Host, srcPath)
+	}
+	if !fi.IsDir() {
+		if err = dst.sftpClient.MkdirAll(path.Dir(dstPath)); err != nil {
+			return errors.Wrapf(err, "mkdir %s:%s failed", dst.Host, path.Dir(dstPath))
+		}
+		return copyRemoteFile(ctx, src, srcPath, dst, dstPath, fi, opt)
+	}
+
+	walker := src.sftpClient.Walk(srcPath)
+	for walker.Step() {
+		if err = walker.Err(); err != nil {
+			return errors.Wrapf(err, "walk %s:%s failed", src.Host, walker.Path())
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), srcPath), "/")
+		target := path.Join(dstPath, rel)
+		info := walker.Stat()
+
+		switch {
+		case info.IsDir():
+			if err = dst.sftpClient.MkdirAll(target); err != nil {
+				return errors.Wrapf(err, "mkdir %s:%s failed", dst.Host, target)
+			}
+			_ = dst.sftpClient.Chmod(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			if err = copyRemoteFile(ctx, src, walker.Path(), dst, target, info, opt); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func copyRemoteFile(ctx context.Context, src *RichSSHClient, srcPath string, dst *RichSSHClient, dstPath string, fi os.FileInfo, opt *RemoteCopyOption) error {
+	resume := opt.Resume
+	for {
+		resumed, err := copyRemoteFileRetrying(ctx, src, srcPath, dst, dstPath, fi, opt, resume)
+		if err != nil {
+			return err
+		}
+		_ = dst.sftpClient.Chmod(dstPath, fi.Mode().Perm())
+		_ = dst.sftpClient.Chtimes(dstPath, fi.ModTime(), fi.ModTime())
+
+		if !opt.Verify {
+			return nil
+		}
+		srcSum, err := src.remoteSHA256(ctx, srcPath)
+		if err != nil {
+			return err
+		}
+		dstSum, err := dst.remoteSHA256(ctx, dstPath)
+		if err != nil {
+			return err
+		}
+		if srcSum == dstSum {
+			return nil
+		}
+		if !resumed {
+			return fmt.Errorf("checksum mismatch %s:%s (%s) != %s:%s (%s)", src.Host, srcPath, srcSum, dst.Host, dstPath, dstSum)
+		}
+		// the resumed destination did not start with the source: copy it again in full
+		resume = false
+	}
+}
+
+// copyRemoteFileRetrying runs copyRemoteFileOnce up to 1+opt.Retries times. It reports whether the
+// successful attempt continued an existing destination.
+func copyRemoteFileRetrying(ctx context.Context, src *RichSSHClient, srcPath string, dst *RichSSHClient, dstPath string, fi os.FileInfo, opt *RemoteCopyOption, resume bool) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		resumed, err := copyRemoteFileOnce(ctx, src.sftpClient, srcPath, dst.sftpClient, dstPath, fi.Size(), opt.Progress, resume)
+		if err == nil {
+			return resumed, nil
+		}
+		if attempt >= opt.Retries || ctx.Err() != nil {
+			return false, errors.Wrapf(err, "copy %s:%s -> %s:%s failed", src.Host, srcPath, dst.Host, dstPath)
+		}
+	}
+}
+
+func copyRemoteFileOnce(ctx context.Context, srcClient *sftp.Client, srcPath string, dstClient *sftp.Client, dstPath string, size int64, progress io.Writer, resume bool) (bool, error) {
+	var offset int64
+	if resume {
+		if dfi, err := dstClient.Stat(dstPath); err == nil && dfi.Size() <= size {
+			offset = dfi.Size()
+		}
+	}
+
+	srcFile, err := srcClient.Open(srcPath)
+	if err != nil {
+		return false, err
+	}
+	defer srcFile.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	dstFile, err := dstClient.OpenFile(dstPath, flags)
+	if err != nil {
+		return false, err
+	}
+	defer dstFile.Close()
+
+	if offset > 0 {
+		if _, err = srcFile.Seek(offset, io.SeekStart); err != nil {
+			return false, err
+		}
+		if _, err = dstFile.Seek(offset, io.SeekStart); err != nil {
+			return false, err
+		}
+	}
+
+	var reader io.Reader = &contextReader{ctx: ctx, r: srcFile}
+	if progress != nil {
+		reader = &progressReader{r: reader, total: size, read: offset, sink: progress}
+	}
+	_, err = io.Copy(dstFile, reader)
+	return offset > 0, err
+}
+
+// remoteSHA256 returns the hex SHA-256 of a remote file, using sha256sum when available
+// and hashing the content over SFTP otherwise.
+func (c *RichSSHClient) remoteSHA256(ctx context.Context, remotePath string) (string, error) {
+	resp, err := c.Run(ctx, "sha256sum "+shellQuote(remotePath))
+	if err == nil && resp.ExitCode == 0 {
+		if fields := strings.Fields(string(resp.Stdout)); len(fields) > 0 && len(fields[0]) == sha256.Size*2 {
+			return fields[0], nil
+		}
+	}
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	if err = c.ensureSFTP(); err != nil {
+		return "", err
+	}
+	f, err := c.sftpClient.Open(remotePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, &contextReader{ctx: ctx, r: f}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// contextReader stops reading once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
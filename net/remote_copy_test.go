@@ -0,0 +1,55 @@
+package net_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+func TestCopyRemoteToRemote(t *testing.T) {
+	a := newTestServer(t)
+	b := newTestServer(t)
+	ca := newTestClient(t, a)
+	cb := newTestClient(t, b)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(a.Root, "backup", "d"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(a.Root, "backup", "d", "db.dump"), bytes.Repeat([]byte("x"), 100000), 0o644))
+
+	dst := filepath.Join(b.Root, "restore")
+	// a partial file from an earlier attempt is resumed
+	require.NoError(t, os.MkdirAll(filepath.Join(dst, "d"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "d", "db.dump"), bytes.Repeat([]byte("x"), 500), 0o644))
+
+	err := net.CopyRemoteToRemote(context.Background(), ca, filepath.Join(a.Root, "backup"), cb, dst, &net.RemoteCopyOption{Verify: true, Resume: true})
+	require.NoError(t, err)
+
+	got, err := os.ReadFile(filepath.Join(dst, "d", "db.dump"))
+	require.NoError(t, err)
+	assert.Len(t, got, 100000)
+	fi, err := os.Stat(filepath.Join(dst, "d", "db.dump"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), fi.Mode().Perm())
+
+	// a stale destination of the same size fails verification and is copied again
+	stale := bytes.Repeat([]byte("y"), 100000)
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "d", "db.dump"), stale, 0o644))
+	err = net.CopyRemoteToRemote(context.Background(), ca, filepath.Join(a.Root, "backup"), cb, dst, &net.RemoteCopyOption{Verify: true, Resume: true})
+	require.NoError(t, err)
+	got, err = os.ReadFile(filepath.Join(dst, "d", "db.dump"))
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("x"), 100000), got)
+
+	// a single file is copied to the given path
+	var progress bytes.Buffer
+	err = net.CopyRemoteToRemote(context.Background(), ca, filepath.Join(a.Root, "backup", "d", "db.dump"), cb, filepath.Join(b.Root, "single", "db.dump"), &net.RemoteCopyOption{Progress: &progress})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(b.Root, "single", "db.dump"))
+	assert.NotEmpty(t, progress.String())
+}
@@ -0,0 +1,104 @@
+package net
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// RetryPolicy controls how RichSSHClient.Connect retries transient failures of the whole
+// connection sequence (proxy dial, jump host, target handshake).
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one; <= 1 disables retries.
+	MaxAttempts int
+	// BaseDelay is the wait before the second attempt, doubled for each further attempt (default 500ms).
+	BaseDelay time.Duration
+	// MaxDelay caps a single wait (default 30s).
+	MaxDelay time.Duration
+	// Jitter randomizes each wait by up to ±Jitter of its length (0..1).
+	Jitter float64
+	// Retryable classifies errors; IsRetryableConnectError is used when nil.
+	Retryable func(err error) bool
+}
+
+// IsRetryableConnectError reports whether err looks transient: refused or reset connections,
+// unreachable networks, timeouts and handshakes cut short by the server (e.g. during a reboot).
+// Authentication failures and context cancellation are not retryable.
+func IsRetryableConnectError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	for _, target := range []error{
+		io.EOF,
+		io.ErrUnexpectedEOF,
+		syscall.ECONNREFUSED,
+		syscall.ECONNRESET,
+		syscall.ECONNABORTED,
+		syscall.EHOSTUNREACH,
+		syscall.ENETUNREACH,
+		syscall.ETIMEDOUT,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// delay returns the wait before attempt n (n >= 1 is the first retry).
+func (p *RetryPolicy) delay(n int) time.Duration {
+	base, maxDelay := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = 500 * time.Millisecond
+	}
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+	d := base
+	for i := 1; i < n && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	if p.Jitter > 0 {
+		d += time.Duration(float64(d) * p.Jitter * (rand.Float64()*2 - 1))
+	}
+	return d
+}
+
+// do runs fn until it succeeds, fails with a non-retryable error, attempts are exhausted or ctx is done.
+// A nil policy runs fn once.
+func (p *RetryPolicy) do(ctx context.Context, fn func() error) error {
+	if p == nil || p.MaxAttempts <= 1 {
+		return fn()
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryableConnectError
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt >= p.MaxAttempts || !retryable(err) {
+			return errors.Wrapf(err, "giving up after %d attempt(s)", attempt)
+		}
+
+		timer := time.NewTimer(p.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package net_test
+
+import (
+	"context"
+	stdnet "net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+func TestConnectRetryPolicy(t *testing.T) {
+	l, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*stdnet.TCPAddr).Port
+	require.NoError(t, l.Close())
+
+	var classified []error
+	c := net.NewRichSSHClient("127.0.0.1", port, "tester", net.WithPassword("secret"), net.WithRetryPolicy(net.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   10 * time.Millisecond,
+		Retryable: func(err error) bool {
+			classified = append(classified, err)
+			return net.IsRetryableConnectError(err)
+		},
+	}))
+	defer c.Close()
+
+	err = c.Connect(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "giving up after 3 attempt(s)")
+	assert.Len(t, classified, 2)
+	assert.True(t, net.IsRetryableConnectError(classified[0]))
+}
+
+func TestConnectRetryPolicyAuthNotRetried(t *testing.T) {
+	srv := newTestServer(t)
+	c := net.NewRichSSHClient(srv.Host, srv.Port, "tester", net.WithPassword("wrong"), net.WithRetryPolicy(net.RetryPolicy{MaxAttempts: 5}))
+	defer c.Close()
+
+	err := c.Connect(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "giving up after 1 attempt(s)")
+}
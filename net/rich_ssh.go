@@ -1,13 +1,13 @@
 package net
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,7 +19,6 @@ import (
 	"github.com/mitchellh/go-homedir"
 
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/net/proxy"
 
 	"github.com/pkg/sftp"
 )
@@ -37,7 +36,19 @@ type RichSSHClient struct {
 	JumpSSHPort    int
 	ProxyURL       string
 
-	EnablePTY bool // default false
+	EnablePTY bool      // default false
+	UseSCP    bool      // transfer files with SCP instead of SFTP; also set automatically when SFTP is unavailable
+	Recorder  *Recorder // records RunStream and Shell sessions in asciicast v2 format when set
+	// Algorithms offered in the handshake, in preference order; empty means the library defaults.
+	// See ModernAlgorithms and LegacyAlgorithms for presets.
+	KeyExchanges      []string
+	Ciphers           []string
+	MACs              []string
+	HostKeyAlgorithms []string
+	// MaxOutputBytes caps the stdout and stderr captured by Run each; 0 means unlimited.
+	MaxOutputBytes int
+
+	commandHooks
 
 	// internals
 	mu          sync.Mutex
@@ -46,12 +57,38 @@ type RichSSHClient struct {
 	sftpClient  *sftp.Client
 	closed      bool
 	dialTimeout time.Duration
+	retryPolicy *RetryPolicy
+	// TLS settings for https:// proxies
+	proxyTLSConfig *tls.Config
+	// custom transport for the first hop (jump host, or target without one)
+	dialContext    dialContextFunc
+	presetConn     net.Conn
+	presetConnUsed bool
 }
 
 type RichSSHClientResponse struct {
+	// ExitCode is the exit status, or 128+n when the command was killed by signal n (as shells report it).
+	// It is -1 for a signal without a known number.
 	ExitCode int
 	Stdout   []byte
 	Stderr   []byte
+	// Signal is the name of the signal that killed the command without the SIG prefix, e.g. "KILL".
+	Signal     string
+	CoreDumped bool
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// StdoutTruncated and StderrTruncated report that output beyond MaxOutputBytes was dropped
+	// from the middle; the head and tail are kept.
+	StdoutTruncated bool
+	StderrTruncated bool
+
+	// total bytes produced, including dropped ones
+	stdoutBytes, stderrBytes int64
+}
+
+// Duration is how long the command ran.
+func (r *RichSSHClientResponse) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
 }
 
 // NewRichSSHClient builds client with defaults and options.
@@ -90,16 +127,92 @@ func WithJumpHost(host string, port int) RichSSHClientOption {
 		c.JumpSSHPort = port
 	}
 }
+
+// WithProxyURL dials through proxyURL (http, https, socks5, socks5h); several comma separated URLs are chained.
 func WithProxyURL(proxyURL string) RichSSHClientOption {
 	return func(c *RichSSHClient) {
 		c.ProxyURL = proxyURL
 	}
 }
+
+// WithProxyTLSConfig sets the TLS configuration used to talk to https:// proxies.
+func WithProxyTLSConfig(cfg *tls.Config) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.proxyTLSConfig = cfg
+	}
+}
+
+// WithProxyCA trusts the PEM encoded CA certificates (instead of the system pool) for https:// proxies.
+func WithProxyCA(pemCerts []byte) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(pemCerts)
+		if c.proxyTLSConfig == nil {
+			c.proxyTLSConfig = &tls.Config{}
+		}
+		c.proxyTLSConfig.RootCAs = pool
+	}
+}
+
+// WithDialContext reaches the first hop (the jump host, or the target without one) through dial
+// instead of TCP and proxy dialing, e.g. for WebSocket gateways or in-memory pipes.
+func WithDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.dialContext = dial
+	}
+}
+
+// WithConn runs SSH over an already open connection to the first hop. It can be used for one connection only.
+func WithConn(conn net.Conn) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.presetConn = conn
+	}
+}
+
+// WithProxyCommand reaches the first hop through a local command speaking over its stdin/stdout,
+// like OpenSSH's ProxyCommand (see ProxyCommandDialer), e.g. `ssh -W %h:%p bastion`.
+func WithProxyCommand(command string) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.dialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return ProxyCommandDialer(command, c.User)(ctx, network, addr)
+		}
+	}
+}
 func WithPTY(enable bool) RichSSHClientOption {
 	return func(c *RichSSHClient) {
 		c.EnablePTY = enable
 	}
 }
+func WithMaxOutputBytes(n int) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.MaxOutputBytes = n
+	}
+}
+func WithSCP(enable bool) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.UseSCP = enable
+	}
+}
+func WithRecorder(r *Recorder) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.Recorder = r
+	}
+}
+func WithBeforeCommandHook(hook BeforeCommandHook) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.beforeHooks = append(c.beforeHooks, hook)
+	}
+}
+func WithAfterCommandHook(hook AfterCommandHook) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.afterHooks = append(c.afterHooks, hook)
+	}
+}
+func WithRetryPolicy(p RetryPolicy) RichSSHClientOption {
+	return func(c *RichSSHClient) {
+		c.retryPolicy = &p
+	}
+}
 func WithDialTimeout(d time.Duration) RichSSHClientOption {
 	return func(c *RichSSHClient) {
 		c.dialTimeout = d
@@ -141,44 +254,71 @@ func (c *RichSSHClient) Connect(ctx context.Context) error {
 		auths = append(auths, ssh.Password(c.Password))
 	}
 
-	sshConfig := &ssh.ClientConfig{
-		User:            c.User,
-		Auth:            auths,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // production: replace
-		Timeout:         c.dialTimeout,
+	if err := c.checkAlgorithms(); err != nil {
+		return err
 	}
+	sshConfig := &ssh.ClientConfig{
+		Config: ssh.Config{
+			KeyExchanges: c.KeyExchanges,
+			Ciphers:      c.Ciphers,
+			MACs:         c.MACs,
+		},
+		User:              c.User,
+		Auth:              auths,
+		HostKeyCallback:   ssh.InsecureIgnoreHostKey(), // production: replace
+		HostKeyAlgorithms: c.HostKeyAlgorithms,
+		Timeout:           c.dialTimeout,
+	}
+
+	return c.retryPolicy.do(ctx, func() error {
+		return c.connectOnce(ctx, sshConfig)
+	})
+}
 
-	// If jump host specified, connect jump first
-	var baseConn net.Conn
-	var err error
-
-	// choose dial function depending on proxy
-	dialFunc := c.netDialContextWithProxy
+// connectOnce makes a single connection attempt, leaving nothing open on failure.
+func (c *RichSSHClient) connectOnce(ctx context.Context, sshConfig *ssh.ClientConfig) error {
+	// choose dial function depending on proxy or custom transport
+	dialFunc := dialContextFunc(c.netDialContextWithProxy)
+	switch {
+	case c.presetConn != nil:
+		if c.presetConnUsed {
+			return errors.New("preset connection already used")
+		}
+		c.presetConnUsed = true
+		conn := c.presetConn
+		dialFunc = func(context.Context, string, string) (net.Conn, error) {
+			return conn, nil
+		}
+	case c.dialContext != nil:
+		dialFunc = c.dialContext
+	}
 
 	targetAddr := fmt.Sprintf("%s:%d", c.Host, c.Port)
+
+	var baseConn net.Conn
+	var jumpClient *ssh.Client
 	if c.JumpSSHHost != "" {
 		// connect jump host (may still need proxy)
 		jumpAddr := fmt.Sprintf("%s:%d", c.JumpSSHHost, c.JumpSSHPort)
-		jumpConn, err1 := dialFunc(ctx, "tcp", jumpAddr)
-		if err1 != nil {
-			return fmt.Errorf("dial jump host: %w", err1)
+		jumpConn, err := dialFunc(ctx, "tcp", jumpAddr)
+		if err != nil {
+			return fmt.Errorf("dial jump host: %w", err)
 		}
 		// upgrade to ssh client over jumpConn
-		jumpClientConn, chans, reqs, err1 := ssh.NewClientConn(jumpConn, jumpAddr, sshConfig)
-		if err1 != nil {
-			jumpConn.Close()
-			return fmt.Errorf("new client conn to jump host: %w", err1)
+		jumpClient, err = sshHandshake(ctx, jumpConn, jumpAddr, sshConfig)
+		if err != nil {
+			return fmt.Errorf("new client conn to jump host: %w", err)
 		}
-		c.jumpClient = ssh.NewClient(jumpClientConn, chans, reqs)
 
 		// now from jumpClient, dial target
-		conn, err1 := c.jumpClient.Dial("tcp", targetAddr)
-		if err1 != nil {
-			return fmt.Errorf("dial target from jump: %w", err1)
+		baseConn, err = jumpClient.DialContext(ctx, "tcp", targetAddr)
+		if err != nil {
+			_ = jumpClient.Close()
+			return fmt.Errorf("dial target from jump: %w", err)
 		}
-		baseConn = conn
 	} else {
 		// direct dial (via proxy if present)
+		var err error
 		baseConn, err = dialFunc(ctx, "tcp", targetAddr)
 		if err != nil {
 			return fmt.Errorf("dial target: %w", err)
@@ -186,81 +326,54 @@ func (c *RichSSHClient) Connect(ctx context.Context) error {
 	}
 
 	// create SSH client over baseConn
-	nc := baseConn
-	clientConn, chans, reqs, err := ssh.NewClientConn(nc, targetAddr, sshConfig)
+	client, err := sshHandshake(ctx, baseConn, targetAddr, sshConfig)
 	if err != nil {
-		nc.Close()
+		if jumpClient != nil {
+			_ = jumpClient.Close()
+		}
 		return fmt.Errorf("ssh new client conn: %w", err)
 	}
-	c.client = ssh.NewClient(clientConn, chans, reqs)
+	c.client = client
+	c.jumpClient = jumpClient
 	return nil
 }
 
-// netDialContextWithProxy supports socks5:// and http(s):// CONNECT, otherwise direct dial.
-func (c *RichSSHClient) netDialContextWithProxy(ctx context.Context, network, addr string) (net.Conn, error) {
-	// if no proxy -> normal dial
-	if c.ProxyURL == "" {
-		d := &net.Dialer{Timeout: c.dialTimeout}
-		return d.DialContext(ctx, network, addr)
+// sshHandshake runs the SSH handshake over conn, aborting when ctx is done or sshConfig.Timeout elapses.
+// conn is closed on failure.
+func sshHandshake(ctx context.Context, conn net.Conn, addr string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	if sshConfig.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(sshConfig.Timeout))
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConfig)
+	if !stop() {
+		if err == nil {
+			_ = clientConn.Close()
+		}
+		return nil, ctx.Err()
 	}
-	u, err := url.Parse(c.ProxyURL)
 	if err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
-	switch strings.ToLower(u.Scheme) {
-	case "socks5", "socks5h", "socks":
-		// use golang.org/x/net/proxy
-		var auth *proxy.Auth
-		if u.User != nil {
-			pw, _ := u.User.Password()
-			auth = &proxy.Auth{User: u.User.Username(), Password: pw}
-		}
-		dialer, err1 := proxy.SOCKS5("tcp", u.Host, auth, proxy.Direct)
-		if err1 != nil {
-			return nil, err1
-		}
-		conn, err1 := dialer.Dial(network, addr)
-		if err1 != nil {
-			return nil, err1
-		}
-		return conn, nil
-	case "http", "https":
-		// implement simple HTTP CONNECT
-		d := &net.Dialer{Timeout: c.dialTimeout}
-		proxyConn, err1 := d.DialContext(ctx, "tcp", u.Host)
-		if err1 != nil {
-			return nil, err1
-		}
+	_ = conn.SetDeadline(time.Time{})
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
 
-		// send CONNECT
-		req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
-		if u.User != nil {
-			pw, _ := u.User.Password()
-			auth := "Basic " + basicAuth(u.User.Username(), pw)
-			req += "Proxy-Authorization: " + auth + "\r\n"
-		}
-		req += "\r\n"
-		if _, err2 := proxyConn.Write([]byte(req)); err2 != nil {
-			proxyConn.Close()
-			return nil, err2
-		}
-		// read response minimal
-		buf := make([]byte, 1024)
-		n, err1 := proxyConn.Read(buf)
-		if err1 != nil {
-			proxyConn.Close()
-			return nil, err1
-		}
-		resp := string(buf[:n])
-		if !strings.Contains(resp, "200") {
-			proxyConn.Close()
-			return nil, fmt.Errorf("proxy connect failed: %s", strings.SplitN(resp, "\r\n", 2)[0])
-		}
-		return proxyConn, nil
-	default:
-		// unsupported scheme
-		return nil, fmt.Errorf("unsupported proxy scheme %s", u.Scheme)
+// netDialContextWithProxy dials through ProxyURL (see ProxyDialer), or through ALL_PROXY when
+// ProxyURL is empty and addr is not excluded by NO_PROXY, otherwise directly.
+func (c *RichSSHClient) netDialContextWithProxy(ctx context.Context, network, addr string) (net.Conn, error) {
+	d, err := NewProxyDialer(strings.Split(c.ProxyURL, ",")...)
+	if err != nil {
+		return nil, err
 	}
+	d.Timeout = c.dialTimeout
+	d.TLSConfig = c.proxyTLSConfig
+	d.UseEnvironment = true
+	return d.DialContext(ctx, network, addr)
 }
 
 func basicAuth(user, pass string) string {
@@ -268,6 +381,14 @@ func basicAuth(user, pass string) string {
 	return base64.StdEncoding.EncodeToString([]byte(enc))
 }
 
+// shellQuote quotes s for safe use as a single POSIX shell word.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
 // Close closes everything.
 func (c *RichSSHClient) Close() {
 	c.mu.Lock()
@@ -292,61 +413,65 @@ func (c *RichSSHClient) Close() {
 
 // Run executes a command and returns output and exit code.
 func (c *RichSSHClient) Run(ctx context.Context, cmd string) (*RichSSHClientResponse, error) {
-	if err := c.Connect(ctx); err != nil {
-		return nil, err
-	}
-	sess, err := c.client.NewSession()
+	return c.RunWithInput(ctx, cmd, nil)
+}
+
+// RunWithInput is Run with stdin fed from the given reader (nil for none).
+func (c *RichSSHClient) RunWithInput(ctx context.Context, cmd string, stdin io.Reader) (*RichSSHClientResponse, error) {
+	ev, err := c.before(ctx, c.Host, c.Port, c.User, cmd)
 	if err != nil {
 		return nil, err
 	}
-	defer sess.Close()
-
-	var outBuf, errBuf bytes.Buffer
-	sess.Stdout = &outBuf
-	sess.Stderr = &errBuf
-
-	// request PTY if enabled
-	if c.EnablePTY {
-		modes := ssh.TerminalModes{
-			ssh.ECHO:          1,
-			ssh.TTY_OP_ISPEED: 14400,
-			ssh.TTY_OP_OSPEED: 14400,
-		}
-		if err1 := sess.RequestPty("xterm", 80, 40, modes); err1 != nil {
-			// if PTY request fails, continue without PTY or return? we return error.
-			return nil, fmt.Errorf("request pty: %w", err1)
-		}
+	resp, err := c.run(ctx, cmd, stdin)
+	ev.ExitCode, ev.Err = -1, err
+	if resp != nil {
+		ev.ExitCode = resp.ExitCode
+		ev.StdoutBytes, ev.StderrBytes = resp.stdoutBytes, resp.stderrBytes
 	}
+	c.after(ctx, ev)
+	return resp, err
+}
 
-	// start and wait, respect ctx
-	errCh := make(chan error, 1)
-	go func() {
-		errCh <- sess.Run(cmd)
-	}()
+func (c *RichSSHClient) run(ctx context.Context, cmd string, stdin io.Reader) (*RichSSHClientResponse, error) {
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
 
-	select {
-	case <-ctx.Done():
-		_ = sess.Signal(ssh.SIGKILL)
-		return nil, ctx.Err()
-	case err1 := <-errCh:
-		resp := &RichSSHClientResponse{Stdout: outBuf.Bytes(), Stderr: errBuf.Bytes()}
-		if err1 == nil {
-			resp.ExitCode = 0
-			return resp, nil
-		}
-		// try to get exit status
-		var ee *ssh.ExitError
-		if errors.As(err1, &ee) {
-			resp.ExitCode = ee.ExitStatus()
-			return resp, nil
-		}
-		return resp, err1
+	outBuf := &headTailBuffer{limit: c.MaxOutputBytes}
+	errBuf := &headTailBuffer{limit: c.MaxOutputBytes}
+	resp := &RichSSHClientResponse{StartedAt: time.Now()}
+	st, err := execChannel(ctx, c.client, cmd, c.EnablePTY, stdin, outBuf, errBuf)
+	if err != nil {
+		return nil, err
 	}
+	resp.FinishedAt = time.Now()
+	resp.Stdout, resp.StdoutTruncated = outBuf.Bytes(), outBuf.truncated()
+	resp.Stderr, resp.StderrTruncated = errBuf.Bytes(), errBuf.truncated()
+	resp.stdoutBytes, resp.stderrBytes = outBuf.total, errBuf.total
+	resp.ExitCode, resp.Signal, resp.CoreDumped = st.code, st.signal, st.coreDumped
+	return resp, nil
 }
 
 // RunStream runs command, piping stdout/stderr to the provided writers in real time.
 // outWriter and errWriter can be nil. PTY respects EnablePTY.
 func (c *RichSSHClient) RunStream(ctx context.Context, cmd string, outWriter, errWriter io.Writer) error {
+	ev, err := c.before(ctx, c.Host, c.Port, c.User, cmd)
+	if err != nil {
+		return err
+	}
+	stdoutCounter := &countingWriter{w: outWriter}
+	stderrCounter := &countingWriter{w: errWriter}
+	err = c.runStream(ctx, cmd, stdoutCounter, stderrCounter)
+	ev.ExitCode, ev.Err = exitCodeOf(err), err
+	ev.StdoutBytes, ev.StderrBytes = stdoutCounter.n.Load(), stderrCounter.n.Load()
+	c.after(ctx, ev)
+	return err
+}
+
+// errExecRefused is returned (wrapped) by runStream when the server rejects the exec request.
+var errExecRefused = errors.New("exec request refused")
+
+func (c *RichSSHClient) runStream(ctx context.Context, cmd string, outWriter, errWriter io.Writer) error {
 	if err := c.Connect(ctx); err != nil {
 		return err
 	}
@@ -375,9 +500,23 @@ func (c *RichSSHClient) RunStream(ctx context.Context, cmd string, outWriter, er
 		return err
 	}
 
+	if c.Recorder != nil {
+		rec, err1 := c.Recorder.start(c.Host, cmd)
+		if err1 != nil {
+			sess.Close()
+			return err1
+		}
+		defer rec.Close()
+		outWriter = rec.writer("o", outWriter)
+		errWriter = rec.writer("o", errWriter)
+	}
+
 	if err1 := sess.Start(cmd); err1 != nil {
 		sess.Close()
-		return err1
+		if errors.Is(err1, io.EOF) {
+			return err1
+		}
+		return fmt.Errorf("%w: %w", errExecRefused, err1)
 	}
 
 	copyErrCh := make(chan error, 2)
@@ -419,6 +558,89 @@ func (c *RichSSHClient) RunStream(ctx context.Context, cmd string, outWriter, er
 	}
 }
 
+// Shell runs an interactive shell on a PTY, wired to stdin/stdout/stderr until the shell exits or ctx is done.
+// The session is recorded when a Recorder is set.
+func (c *RichSSHClient) Shell(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+	ev, err := c.before(ctx, c.Host, c.Port, c.User, "")
+	if err != nil {
+		return err
+	}
+	stdoutCounter := &countingWriter{w: stdout}
+	stderrCounter := &countingWriter{w: stderr}
+	err = c.shell(ctx, stdin, stdoutCounter, stderrCounter)
+	ev.ExitCode, ev.Err = exitCodeOf(err), err
+	ev.StdoutBytes, ev.StderrBytes = stdoutCounter.n.Load(), stderrCounter.n.Load()
+	c.after(ctx, ev)
+	return err
+}
+
+func (c *RichSSHClient) shell(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+	sess, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	width, height := 80, 24
+	if c.Recorder != nil && c.Recorder.Width > 0 && c.Recorder.Height > 0 {
+		width, height = c.Recorder.Width, c.Recorder.Height
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err = sess.RequestPty("xterm", height, width, modes); err != nil {
+		return fmt.Errorf("request pty: %w", err)
+	}
+
+	if c.Recorder != nil {
+		rec, err1 := c.Recorder.start(c.Host, "")
+		if err1 != nil {
+			return err1
+		}
+		defer rec.Close()
+		stdout = rec.writer("o", stdout)
+		stderr = rec.writer("o", stderr)
+		if stdin != nil && c.Recorder.RecordInput {
+			stdin = io.TeeReader(stdin, rec.writer("i", nil))
+		}
+	}
+	sess.Stdout = stdout
+	sess.Stderr = stderr
+
+	// copy stdin ourselves: session.Wait would otherwise block on a stdin that never reaches EOF
+	in, err := sess.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err = sess.Shell(); err != nil {
+		return err
+	}
+	if stdin != nil {
+		go func() {
+			_, _ = io.Copy(in, stdin)
+			_ = in.Close()
+		}()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sess.Wait()
+	}()
+
+	select {
+	case <-ctx.Done():
+		_ = sess.Signal(ssh.SIGKILL)
+		return ctx.Err()
+	case err1 := <-done:
+		return err1
+	}
+}
+
 // ensureSFTP initializes sftp client lazily.
 func (c *RichSSHClient) ensureSFTP() error {
 	c.mu.Lock()
@@ -437,14 +659,59 @@ func (c *RichSSHClient) ensureSFTP() error {
 	return nil
 }
 
+// useSCP reports whether transfers go through SCP, either on request or because the server
+// does not provide SFTP (the fallback is remembered). Other failures to start SFTP are returned.
+func (c *RichSSHClient) useSCP() (bool, error) {
+	c.mu.Lock()
+	useSCP := c.UseSCP
+	c.mu.Unlock()
+	if useSCP {
+		return true, nil
+	}
+	err := c.ensureSFTP()
+	if err == nil {
+		return false, nil
+	}
+	if !c.sftpRefused(err) {
+		return false, errors.Wrap(err, "sftp unavailable")
+	}
+	c.mu.Lock()
+	c.UseSCP = true
+	c.mu.Unlock()
+	return true, nil
+}
+
+// sftpRefused reports whether err, returned by ensureSFTP, means the server refused or could not
+// run the sftp subsystem, rather than that no channel could be opened or the connection failed.
+func (c *RichSSHClient) sftpRefused(err error) bool {
+	var oce *ssh.OpenChannelError
+	if errors.As(err, &oce) {
+		return false
+	}
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+	if client == nil {
+		return false
+	}
+	// the connection must still be usable
+	_, _, err = client.SendRequest("keepalive@openssh.com", true, nil)
+	return err == nil
+}
+
 // UploadFile uploads localPath -> remotePath. If progressWriter != nil, it will be written with bytes transferred.
+// Falls back to SCP when SFTP is unavailable.
 func (c *RichSSHClient) UploadFile(ctx context.Context, localPath, remotePath string, progressWriter io.Writer) error {
 	if err := c.Connect(ctx); err != nil {
 		return err
 	}
-	if err := c.ensureSFTP(); err != nil {
+	scp, err := c.useSCP()
+	if err != nil {
 		return err
 	}
+	if scp {
+		return c.SCPUpload(ctx, localPath, remotePath, false, progressWriter)
+	}
 
 	srcFile, err := os.Open(localPath)
 	if err != nil {
@@ -474,13 +741,19 @@ func (c *RichSSHClient) UploadFile(ctx context.Context, localPath, remotePath st
 }
 
 // DownloadFile downloads remotePath -> localPath. If progressWriter != nil, it will be written with bytes transferred.
+// Falls back to SCP when SFTP is unavailable.
 func (c *RichSSHClient) DownloadFile(ctx context.Context, remotePath, localPath string, progressWriter io.Writer) error {
 	if err := c.Connect(ctx); err != nil {
 		return err
 	}
-	if err := c.ensureSFTP(); err != nil {
+	scp, err := c.useSCP()
+	if err != nil {
 		return err
 	}
+	if scp {
+		_ = os.MkdirAll(filepath.Dir(localPath), 0o755)
+		return c.SCPDownload(ctx, remotePath, localPath, false, progressWriter)
+	}
 
 	srcFile, err := c.sftpClient.Open(remotePath)
 	if err != nil {
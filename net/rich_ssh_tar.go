@@ -0,0 +1,156 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/designinlife/slib/errors"
+	"github.com/designinlife/slib/fs"
+)
+
+// UploadDirTar streams localDir into remoteDir as a tar pipe (`tar -xf - -C remoteDir`),
+// without temp files on either side. If compress is true the stream is gzip compressed.
+// remoteDir is created when missing.
+func (c *RichSSHClient) UploadDirTar(ctx context.Context, localDir, remoteDir string, compress bool) (err error) {
+	if !fs.IsDir(localDir) {
+		return fmt.Errorf("directory does not exist: %s", localDir)
+	}
+
+	flags := "-xf"
+	if compress {
+		flags = "-xzf"
+	}
+	cmd := fmt.Sprintf("mkdir -p %s && tar %s - -C %s", shellQuote(remoteDir), flags, shellQuote(remoteDir))
+
+	ev, err := c.before(ctx, c.Host, c.Port, c.User, cmd)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ev.ExitCode, ev.Err = exitCodeOf(err), err
+		c.after(ctx, ev)
+	}()
+
+	if err = c.Connect(ctx); err != nil {
+		return err
+	}
+	sess, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return err
+	}
+	var errBuf bytes.Buffer
+	sess.Stderr = &errBuf
+
+	if err1 := sess.Start(cmd); err1 != nil {
+		return err1
+	}
+
+	writeErrCh := make(chan error, 1)
+	go func() {
+		err1 := fs.TarDirToWriter(stdin, localDir, "", compress)
+		_ = stdin.Close()
+		writeErrCh <- err1
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sess.Wait()
+	}()
+
+	select {
+	case <-ctx.Done():
+		_ = sess.Signal(ssh.SIGKILL)
+		return ctx.Err()
+	case err1 := <-done:
+		werr := <-writeErrCh
+		if werr != nil {
+			return errors.Wrapf(werr, "stream %s to %s failed", localDir, remoteDir)
+		}
+		if err1 != nil {
+			return errors.Wrapf(err1, "remote tar extract failed: %s", strings.TrimSpace(errBuf.String()))
+		}
+		return nil
+	}
+}
+
+// DownloadDirTar streams remoteDir into localDir through `tar -cf - -C remoteDir .`,
+// extracting on the fly without temp files. If compress is true the stream is gzip compressed.
+func (c *RichSSHClient) DownloadDirTar(ctx context.Context, remoteDir, localDir string, compress bool) (err error) {
+	flags := "-cf"
+	if compress {
+		flags = "-czf"
+	}
+	cmd := fmt.Sprintf("tar %s - -C %s .", flags, shellQuote(remoteDir))
+
+	ev, err := c.before(ctx, c.Host, c.Port, c.User, cmd)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ev.ExitCode, ev.Err = exitCodeOf(err), err
+		c.after(ctx, ev)
+	}()
+
+	if err = c.Connect(ctx); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(localDir, 0o755); err != nil {
+		return err
+	}
+	sess, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var errBuf bytes.Buffer
+	sess.Stderr = &errBuf
+
+	if err1 := sess.Start(cmd); err1 != nil {
+		return err1
+	}
+
+	readErrCh := make(chan error, 1)
+	go func() {
+		err1 := fs.UntarFromReader(stdout, localDir, compress)
+		// drain trailing padding so the remote side can exit
+		_, _ = io.Copy(io.Discard, stdout)
+		readErrCh <- err1
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sess.Wait()
+	}()
+
+	select {
+	case <-ctx.Done():
+		_ = sess.Signal(ssh.SIGKILL)
+		return ctx.Err()
+	case err1 := <-done:
+		rerr := <-readErrCh
+		if err1 != nil {
+			return errors.Wrapf(err1, "remote tar create failed: %s", strings.TrimSpace(errBuf.String()))
+		}
+		if rerr != nil {
+			return errors.Wrapf(rerr, "extract %s to %s failed", remoteDir, localDir)
+		}
+		return nil
+	}
+}
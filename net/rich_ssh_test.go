@@ -0,0 +1,219 @@
+package net_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+	"github.com/designinlife/slib/net/sshtest"
+)
+
+func newTestServer(t *testing.T, opts ...sshtest.Option) *sshtest.Server {
+	t.Helper()
+	opts = append([]sshtest.Option{sshtest.WithPassword("tester", "secret"), sshtest.WithExecHandler(sshtest.ShellExec)}, opts...)
+	srv, err := sshtest.NewServer(opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv
+}
+
+func newTestClient(t *testing.T, srv *sshtest.Server, opts ...net.RichSSHClientOption) *net.RichSSHClient {
+	t.Helper()
+	opts = append([]net.RichSSHClientOption{net.WithPassword("secret")}, opts...)
+	c := net.NewRichSSHClient(srv.Host, srv.Port, "tester", opts...)
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestRichSSHClientRun(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+
+	resp, err := c.Run(context.Background(), "echo out; echo err >&2; exit 3")
+	require.NoError(t, err)
+	assert.Equal(t, 3, resp.ExitCode)
+	assert.Equal(t, "out\n", string(resp.Stdout))
+	assert.Equal(t, "err\n", string(resp.Stderr))
+}
+
+func TestRichSSHClientRunContextCancel(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	require.NoError(t, c.Connect(ctx))
+
+	_, err := c.Run(ctx, "sleep 5")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRichSSHClientAuthFailure(t *testing.T) {
+	srv := newTestServer(t)
+	c := net.NewRichSSHClient(srv.Host, srv.Port, "tester", net.WithPassword("wrong"))
+	defer c.Close()
+
+	assert.Error(t, c.Connect(context.Background()))
+}
+
+func TestRichSSHClientJumpHost(t *testing.T) {
+	jump := newTestServer(t)
+	target := newTestServer(t)
+	c := newTestClient(t, target, net.WithJumpHost(jump.Host, jump.Port))
+
+	resp, err := c.Run(context.Background(), "echo via-jump")
+	require.NoError(t, err)
+	assert.Equal(t, "via-jump\n", string(resp.Stdout))
+}
+
+func TestRichSSHClientUploadDownload(t *testing.T) {
+	for name, opts := range map[string][]sshtest.Option{
+		"sftp":         nil,
+		"scp-fallback": {sshtest.WithoutSFTP()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := newTestServer(t, opts...)
+			c := newTestClient(t, srv)
+			ctx := context.Background()
+
+			local := filepath.Join(t.TempDir(), "a.txt")
+			require.NoError(t, os.WriteFile(local, []byte("hello world"), 0o644))
+
+			remote := filepath.Join(srv.Root, "sub", "a.txt")
+			var progress bytes.Buffer
+			require.NoError(t, c.UploadFile(ctx, local, remote, &progress))
+			assert.Contains(t, progress.String(), "11/11")
+
+			b, err := os.ReadFile(remote)
+			require.NoError(t, err)
+			assert.Equal(t, "hello world", string(b))
+
+			back := filepath.Join(t.TempDir(), "b.txt")
+			require.NoError(t, c.DownloadFile(ctx, remote, back, nil))
+			b, err = os.ReadFile(back)
+			require.NoError(t, err)
+			assert.Equal(t, "hello world", string(b))
+			assert.Equal(t, name == "scp-fallback", c.UseSCP)
+		})
+	}
+}
+
+func TestRichSSHClientNoSCPFallbackOnConnectionError(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+	require.NoError(t, c.Connect(ctx))
+
+	local := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, os.WriteFile(local, []byte("hello"), 0o644))
+	require.NoError(t, srv.Close())
+	assert.Error(t, c.UploadFile(ctx, local, filepath.Join(srv.Root, "a.txt"), nil))
+	assert.False(t, c.UseSCP)
+}
+
+func TestRichSSHClientDirTransfers(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	src := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "x", "y"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "x", "y", "f.txt"), []byte("data"), 0o600))
+
+	for _, compress := range []bool{false, true} {
+		remote := filepath.Join(srv.Root, "tar", map[bool]string{false: "plain", true: "gz"}[compress])
+		require.NoError(t, c.UploadDirTar(ctx, src, remote, compress))
+		b, err := os.ReadFile(filepath.Join(remote, "x", "y", "f.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(b))
+
+		local := t.TempDir()
+		require.NoError(t, c.DownloadDirTar(ctx, remote, local, compress))
+		b, err = os.ReadFile(filepath.Join(local, "x", "y", "f.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(b))
+	}
+
+	remote := filepath.Join(srv.Root, "scp")
+	require.NoError(t, c.SCPUpload(ctx, src, remote, true, nil))
+	b, err := os.ReadFile(filepath.Join(remote, "x", "y", "f.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(b))
+
+	local := filepath.Join(t.TempDir(), "scp-back")
+	require.NoError(t, c.SCPDownload(ctx, remote, local, true, nil))
+	fi, err := os.Stat(filepath.Join(local, "x", "y", "f.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+}
+
+func TestSCPEmptyRecord(t *testing.T) {
+	srv := newTestServer(t, sshtest.WithExecHandler(func(req *sshtest.ExecRequest) int {
+		ack := make([]byte, 1)
+		_, _ = req.Stdin.Read(ack)
+		_, _ = req.Stdout.Write([]byte("\n"))
+		_, _ = req.Stdin.Read(ack) // until the client gives up
+		return 0
+	}))
+	c := newTestClient(t, srv)
+
+	err := c.SCPDownload(context.Background(), "f.txt", filepath.Join(t.TempDir(), "f.txt"), false, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "empty record")
+}
+
+func TestRichSSHClientRunExitSignal(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+
+	resp, err := c.Run(context.Background(), "echo before; kill -TERM $$")
+	require.NoError(t, err)
+	assert.Equal(t, "TERM", resp.Signal)
+	assert.Equal(t, 143, resp.ExitCode)
+	assert.False(t, resp.CoreDumped)
+	assert.Equal(t, "before\n", string(resp.Stdout))
+	assert.False(t, resp.StartedAt.IsZero())
+	assert.GreaterOrEqual(t, resp.Duration(), time.Duration(0))
+
+	srv = newTestServer(t, sshtest.WithExecHandler(func(req *sshtest.ExecRequest) int {
+		req.SetExitSignal("SEGV", true)
+		return 0
+	}))
+	c = newTestClient(t, srv)
+	resp, err = c.Run(context.Background(), "crash")
+	require.NoError(t, err)
+	assert.Equal(t, "SEGV", resp.Signal)
+	assert.Equal(t, 139, resp.ExitCode)
+	assert.True(t, resp.CoreDumped)
+
+	srv = newTestServer(t, sshtest.WithExecHandler(func(req *sshtest.ExecRequest) int {
+		req.SetExitSignal("XCPU@example.com", false)
+		return 0
+	}))
+	c = newTestClient(t, srv)
+	resp, err = c.Run(context.Background(), "crash")
+	require.NoError(t, err)
+	assert.Equal(t, "XCPU@example.com", resp.Signal)
+	assert.Equal(t, -1, resp.ExitCode)
+}
+
+func TestRichSSHClientRunMaxOutputBytes(t *testing.T) {
+	srv := newTestServer(t)
+	var ev *net.CommandEvent
+	c := newTestClient(t, srv, net.WithMaxOutputBytes(8), net.WithAfterCommandHook(func(_ context.Context, e *net.CommandEvent) { ev = e }))
+
+	resp, err := c.Run(context.Background(), "printf 'abcdefghijklmnopqrstuvwxyz'; printf 'short' >&2")
+	require.NoError(t, err)
+	assert.Equal(t, "abcdwxyz", string(resp.Stdout))
+	assert.True(t, resp.StdoutTruncated)
+	assert.Equal(t, "short", string(resp.Stderr))
+	assert.False(t, resp.StderrTruncated)
+	assert.Equal(t, int64(26), ev.StdoutBytes)
+}
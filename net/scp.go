@@ -0,0 +1,371 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// SCPUpload copies localPath to remotePath with the SCP protocol (`scp -t`) over a plain exec session,
+// for servers where the SFTP subsystem is disabled. Directories are copied recursively.
+// If preserve is true, modification times and modes are kept.
+func (c *RichSSHClient) SCPUpload(ctx context.Context, localPath, remotePath string, preserve bool, progressWriter io.Writer) error {
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if err = c.Connect(ctx); err != nil {
+		return err
+	}
+
+	flags := "-t"
+	if fi.IsDir() {
+		flags += " -r"
+	}
+	if preserve {
+		flags += " -p"
+	}
+	cmd := fmt.Sprintf("mkdir -p %s && scp %s %s", shellQuote(path.Dir(remotePath)), flags, shellQuote(remotePath))
+
+	return c.runSCP(ctx, cmd, func(w io.Writer, r *bufio.Reader) error {
+		src := &scpSource{w: w, r: r, preserve: preserve, progress: progressWriter}
+		if err1 := readSCPAck(r); err1 != nil {
+			return err1
+		}
+		return src.send(localPath, fi)
+	})
+}
+
+// SCPDownload copies remotePath to localPath with the SCP protocol (`scp -f`) over a plain exec session.
+// Directories are copied recursively. If localPath is an existing directory, the remote entry is created inside it.
+// If preserve is true, modification times and modes are kept.
+func (c *RichSSHClient) SCPDownload(ctx context.Context, remotePath, localPath string, preserve bool, progressWriter io.Writer) error {
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+
+	flags := "-f -r"
+	if preserve {
+		flags += " -p"
+	}
+	cmd := fmt.Sprintf("scp %s %s", flags, shellQuote(remotePath))
+
+	return c.runSCP(ctx, cmd, func(w io.Writer, r *bufio.Reader) error {
+		sink := &scpSink{w: w, r: r, preserve: preserve, progress: progressWriter}
+		return sink.receive(localPath)
+	})
+}
+
+// runSCP starts cmd on a new session and hands its stdin/stdout to fn, which speaks the SCP protocol.
+func (c *RichSSHClient) runSCP(ctx context.Context, cmd string, fn func(w io.Writer, r *bufio.Reader) error) (err error) {
+	ev, err := c.before(ctx, c.Host, c.Port, c.User, cmd)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ev.ExitCode, ev.Err = exitCodeOf(err), err
+		c.after(ctx, ev)
+	}()
+
+	sess, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var errBuf bytes.Buffer
+	sess.Stderr = &errBuf
+
+	if err = sess.Start(cmd); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err1 := fn(stdin, bufio.NewReader(stdout))
+		_ = stdin.Close()
+		_, _ = io.Copy(io.Discard, stdout)
+		if werr := sess.Wait(); err1 == nil && werr != nil {
+			err1 = errors.Wrapf(werr, "scp failed: %s", strings.TrimSpace(errBuf.String()))
+		}
+		done <- err1
+	}()
+
+	select {
+	case <-ctx.Done():
+		_ = sess.Signal(ssh.SIGKILL)
+		return ctx.Err()
+	case err1 := <-done:
+		return err1
+	}
+}
+
+// readSCPAck reads one SCP status reply: 0 ok, 1 warning, 2 fatal error (the latter followed by a message line).
+func readSCPAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("scp: read ack: %w", err)
+	}
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		msg, _ := r.ReadString('\n')
+		return fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+	default:
+		return fmt.Errorf("scp: unexpected response byte %#x", b)
+	}
+}
+
+type scpSource struct {
+	w        io.Writer
+	r        *bufio.Reader
+	preserve bool
+	progress io.Writer
+}
+
+func (s *scpSource) send(localPath string, fi os.FileInfo) error {
+	if s.preserve {
+		mt := fi.ModTime().Unix()
+		if _, err := fmt.Fprintf(s.w, "T%d 0 %d 0\n", mt, mt); err != nil {
+			return err
+		}
+		if err := readSCPAck(s.r); err != nil {
+			return err
+		}
+	}
+
+	if fi.IsDir() {
+		if _, err := fmt.Fprintf(s.w, "D%04o 0 %s\n", fi.Mode().Perm(), fi.Name()); err != nil {
+			return err
+		}
+		if err := readSCPAck(s.r); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(localPath)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			p := filepath.Join(localPath, e.Name())
+			info, err1 := os.Stat(p)
+			if err1 != nil {
+				return err1
+			}
+			if err1 = s.send(p, info); err1 != nil {
+				return err1
+			}
+		}
+		if _, err = io.WriteString(s.w, "E\n"); err != nil {
+			return err
+		}
+		return readSCPAck(s.r)
+	}
+
+	if !fi.Mode().IsRegular() {
+		// devices, sockets etc. are not transferable
+		return nil
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = fmt.Fprintf(s.w, "C%04o %d %s\n", fi.Mode().Perm(), fi.Size(), fi.Name()); err != nil {
+		return err
+	}
+	if err = readSCPAck(s.r); err != nil {
+		return err
+	}
+	var reader io.Reader = f
+	if s.progress != nil {
+		reader = &progressReader{r: f, total: fi.Size(), sink: s.progress}
+	}
+	if _, err = io.CopyN(s.w, reader, fi.Size()); err != nil {
+		return fmt.Errorf("scp: send %s: %w", localPath, err)
+	}
+	if _, err = s.w.Write([]byte{0}); err != nil {
+		return err
+	}
+	return readSCPAck(s.r)
+}
+
+type scpSink struct {
+	w        io.Writer
+	r        *bufio.Reader
+	preserve bool
+	progress io.Writer
+}
+
+type scpSinkDir struct {
+	path  string
+	mtime time.Time
+	atime time.Time
+	times bool
+}
+
+func (s *scpSink) ack() error {
+	_, err := s.w.Write([]byte{0})
+	return err
+}
+
+func (s *scpSink) receive(target string) error {
+	var (
+		dirs         []scpSinkDir
+		mtime, atime time.Time
+		hasTimes     bool
+	)
+	targetIsDir := false
+	if fi, err := os.Stat(target); err == nil && fi.IsDir() {
+		targetIsDir = true
+	}
+
+	if err := s.ack(); err != nil {
+		return err
+	}
+
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" {
+				return nil
+			}
+			return fmt.Errorf("scp: read record: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return errors.New("scp: empty record")
+		}
+
+		switch line[0] {
+		case 1, 2:
+			return fmt.Errorf("scp: %s", strings.TrimSpace(line[1:]))
+		case 'T':
+			var m, mu, a, au int64
+			if _, err = fmt.Sscanf(line[1:], "%d %d %d %d", &m, &mu, &a, &au); err != nil {
+				return fmt.Errorf("scp: bad time record %q", line)
+			}
+			mtime, atime, hasTimes = time.Unix(m, mu*1000), time.Unix(a, au*1000), true
+			if err = s.ack(); err != nil {
+				return err
+			}
+		case 'E':
+			if len(dirs) == 0 {
+				return errors.New("scp: unbalanced end of directory record")
+			}
+			d := dirs[len(dirs)-1]
+			dirs = dirs[:len(dirs)-1]
+			if s.preserve && d.times {
+				_ = os.Chtimes(d.path, d.atime, d.mtime)
+			}
+			if err = s.ack(); err != nil {
+				return err
+			}
+		case 'C', 'D':
+			fields := strings.SplitN(line[1:], " ", 3)
+			if len(fields) != 3 {
+				return fmt.Errorf("scp: bad record %q", line)
+			}
+			mode, err1 := strconv.ParseUint(fields[0], 8, 32)
+			if err1 != nil {
+				return fmt.Errorf("scp: bad mode in record %q", line)
+			}
+			size, err1 := strconv.ParseInt(fields[1], 10, 64)
+			if err1 != nil || size < 0 {
+				return fmt.Errorf("scp: bad size in record %q", line)
+			}
+			name := fields[2]
+			if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+				return fmt.Errorf("scp: unsafe file name %q", name)
+			}
+
+			var p string
+			switch {
+			case len(dirs) > 0:
+				p = filepath.Join(dirs[len(dirs)-1].path, name)
+			case targetIsDir:
+				p = filepath.Join(target, name)
+			default:
+				p = target
+			}
+
+			if line[0] == 'D' {
+				if err = os.MkdirAll(p, 0o755); err != nil {
+					return err
+				}
+				if s.preserve {
+					_ = os.Chmod(p, os.FileMode(mode).Perm())
+				}
+				dirs = append(dirs, scpSinkDir{path: p, mtime: mtime, atime: atime, times: hasTimes})
+				hasTimes = false
+				if err = s.ack(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err = s.receiveFile(p, os.FileMode(mode).Perm(), size); err != nil {
+				return err
+			}
+			if s.preserve && hasTimes {
+				_ = os.Chtimes(p, atime, mtime)
+			}
+			hasTimes = false
+		default:
+			return fmt.Errorf("scp: unknown record %q", line)
+		}
+	}
+}
+
+func (s *scpSink) receiveFile(p string, mode os.FileMode, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if err = s.ack(); err != nil {
+		f.Close()
+		return err
+	}
+
+	var reader io.Reader = io.LimitReader(s.r, size)
+	if s.progress != nil {
+		reader = &progressReader{r: reader, total: size, sink: s.progress}
+	}
+	_, err = io.CopyN(f, reader, size)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("scp: receive %s: %w", p, err)
+	}
+	if s.preserve {
+		_ = os.Chmod(p, mode)
+	}
+	if err = readSCPAck(s.r); err != nil {
+		return err
+	}
+	return s.ack()
+}
@@ -0,0 +1,127 @@
+package net
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// Script is a script for RunScript, read from a local Path or given as Content.
+type Script struct {
+	// Path is a local script file, used when Content is empty.
+	Path    string
+	Content []byte
+	// Interpreter runs the script, e.g. "bash" or "python3". When empty, the uploaded file is executed
+	// directly (its shebang decides), or piped to "sh" in Stdin mode.
+	Interpreter string
+	Args        []string
+	Env         map[string]string
+	// Stdin pipes the script into the interpreter's stdin instead of uploading a temp file.
+	// Shell interpreters get "-s --", others "-" (as python, perl and ruby expect).
+	Stdin bool
+}
+
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// RunScript streams a script to a private remote temp file (mode 0700), runs it with the interpreter,
+// args and env, and always removes the temp file again, even when ctx is cancelled.
+// The response is that of the script run itself.
+func (c *RichSSHClient) RunScript(ctx context.Context, script *Script) (*RichSSHClientResponse, error) {
+	content := script.Content
+	if len(content) == 0 {
+		if script.Path == "" {
+			return nil, errors.New("script has neither content nor path")
+		}
+		b, err := os.ReadFile(script.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read script %s failed", script.Path)
+		}
+		content = b
+	}
+
+	prefix, err := scriptEnvPrefix(script.Env)
+	if err != nil {
+		return nil, err
+	}
+	// a blank interpreter counts as none
+	interpreter := strings.TrimSpace(script.Interpreter)
+	args := make([]string, 0, len(script.Args))
+	for _, a := range script.Args {
+		args = append(args, shellQuote(a))
+	}
+
+	if script.Stdin {
+		if interpreter == "" {
+			interpreter = "sh"
+		}
+		flag := "-"
+		switch path.Base(strings.Fields(interpreter)[0]) {
+		case "sh", "bash", "dash", "zsh", "ksh", "ash":
+			flag = "-s --"
+		}
+		cmd := strings.TrimSpace(fmt.Sprintf("%s%s %s %s", prefix, interpreter, flag, strings.Join(args, " ")))
+		return c.RunWithInput(ctx, cmd, strings.NewReader(string(content)))
+	}
+
+	resp, err := c.Run(ctx, "umask 077 && mktemp")
+	if err != nil {
+		return nil, errors.Wrap(err, "create remote temp file failed")
+	}
+	if resp.ExitCode != 0 {
+		return nil, fmt.Errorf("create remote temp file failed: %s", strings.TrimSpace(string(resp.Stderr)))
+	}
+	tmp := strings.TrimSpace(string(resp.Stdout))
+	if tmp == "" {
+		return nil, errors.New("create remote temp file failed: mktemp returned no path")
+	}
+	defer func() {
+		// clean up even if ctx is already cancelled
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		_, _ = c.Run(cleanupCtx, "rm -f "+shellQuote(tmp))
+	}()
+
+	resp, err = c.RunWithInput(ctx, fmt.Sprintf("cat > %s && chmod 700 %s", shellQuote(tmp), shellQuote(tmp)), strings.NewReader(string(content)))
+	if err != nil {
+		return nil, errors.Wrap(err, "upload script failed")
+	}
+	if resp.ExitCode != 0 {
+		return nil, fmt.Errorf("upload script failed: %s", strings.TrimSpace(string(resp.Stderr)))
+	}
+
+	cmd := shellQuote(tmp)
+	if interpreter != "" {
+		cmd = interpreter + " " + cmd
+	}
+	cmd = strings.TrimSpace(prefix + cmd + " " + strings.Join(args, " "))
+	return c.Run(ctx, cmd)
+}
+
+// scriptEnvPrefix renders env as an `env K=V ... ` command prefix, sorted by name.
+func scriptEnvPrefix(env map[string]string) (string, error) {
+	if len(env) == 0 {
+		return "", nil
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		if !envNameRe.MatchString(k) {
+			return "", fmt.Errorf("invalid environment variable name %q", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString("env ")
+	for _, k := range keys {
+		sb.WriteString(k + "=" + shellQuote(env[k]) + " ")
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,43 @@
+package net_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+func TestRunScript(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	script := filepath.Join(t.TempDir(), "deploy.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"$GREETING $1\" \"$0\"\nexit 5\n"), 0o644))
+
+	resp, err := c.RunScript(ctx, &net.Script{Path: script, Args: []string{"it's me"}, Env: map[string]string{"GREETING": "hello"}})
+	require.NoError(t, err)
+	assert.Equal(t, 5, resp.ExitCode)
+	assert.Regexp(t, `^hello it's me /`, string(resp.Stdout))
+
+	// the temp file is gone
+	_, err = os.Stat(strings.TrimSpace(strings.TrimPrefix(string(resp.Stdout), "hello it's me ")))
+	assert.True(t, os.IsNotExist(err))
+
+	resp, err = c.RunScript(ctx, &net.Script{Content: []byte("echo \"$1-$2\"\n"), Interpreter: "bash", Args: []string{"a", "b"}, Stdin: true})
+	require.NoError(t, err)
+	assert.Equal(t, "a-b\n", string(resp.Stdout))
+
+	resp, err = c.RunScript(ctx, &net.Script{Content: []byte("echo piped\n"), Interpreter: "  ", Stdin: true})
+	require.NoError(t, err)
+	assert.Equal(t, "piped\n", string(resp.Stdout))
+
+	_, err = c.RunScript(ctx, &net.Script{Content: []byte("true"), Env: map[string]string{"BAD-NAME": "x"}})
+	assert.Error(t, err)
+}
@@ -1,19 +1,18 @@
 package net
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net"
-	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mitchellh/go-homedir"
-	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/net/proxy"
 	"golang.org/x/term"
 
 	"github.com/designinlife/slib/errors"
@@ -38,18 +37,32 @@ type SSHTunnel struct {
 	Remote *SSHTunnelEndpoint
 	// SSH 客户端配置
 	Config *ssh.ClientConfig
-	exit   chan bool
+
+	initOnce sync.Once
+	stopOnce sync.Once
+	exit     chan bool
+}
+
+// init 创建退出通知通道 (SSHTunnel 通常以字面量构造)。
+func (tunnel *SSHTunnel) init() {
+	tunnel.initOnce.Do(func() {
+		tunnel.exit = make(chan bool)
+	})
 }
 
+// Start 监听本地端口并转发连接，直到 Stop 被调用。就绪时向 opened 发送 true；监听失败时关闭 opened。
 func (tunnel *SSHTunnel) Start(opened chan bool) {
+	tunnel.init()
+
 	listener, err := net.Listen("tcp", tunnel.Local.String())
 	if err != nil {
 		glog.Error(errors.Wrapf(err, "SSHTunnel Start net Listen %s failed", tunnel.Local.String()))
+		close(opened)
 		return
 	}
 	defer func() {
 		err1 := listener.Close()
-		if err1 != nil {
+		if err1 != nil && !errors.Is(err1, net.ErrClosed) {
 			glog.Error(err1)
 		}
 	}()
@@ -58,6 +71,7 @@ func (tunnel *SSHTunnel) Start(opened chan bool) {
 		addr, ok := listener.Addr().(*net.TCPAddr)
 		if !ok {
 			glog.Error(errors.New("SSHTunnel Start Get Local Port Failed"))
+			close(opened)
 			return
 		}
 		tunnel.Local.Port = addr.Port
@@ -65,51 +79,70 @@ func (tunnel *SSHTunnel) Start(opened chan bool) {
 
 	glog.Debugf("[SSHTunnel] Listen: %s", tunnel.Local.String())
 
+	go func() {
+		<-tunnel.exit
+		_ = listener.Close()
+	}()
+
 	opened <- true
 
 	for {
 		conn, err1 := listener.Accept()
 		if err1 != nil {
-			glog.Fatal(errors.Wrap(err, "SSHTunnel Start net Accept failed"))
+			select {
+			case <-tunnel.exit:
+				glog.Infof("SSHTunnel %s exited.", tunnel.Local.String())
+			default:
+				glog.Error(errors.Wrap(err1, "SSHTunnel Start net Accept failed"))
+			}
+			return
 		}
 		go tunnel.forward(conn)
-
-		<-tunnel.exit
-
-		glog.Infof("SSHTunnel %s exited.", tunnel.Local.String())
 	}
 }
 
+// Stop 停止监听。可重复调用，未启动的隧道也可调用。
 func (tunnel *SSHTunnel) Stop() {
-	close(tunnel.exit)
+	tunnel.init()
+	tunnel.stopOnce.Do(func() {
+		close(tunnel.exit)
+	})
 }
 
 func (tunnel *SSHTunnel) forward(localConn net.Conn) {
+	defer localConn.Close()
+
 	serverConn, err := ssh.Dial("tcp", tunnel.Server.String(), tunnel.Config)
 	if err != nil {
 		// logger.Errorf("[SSHTunnel] Server dial error: %s", err)
 		_, _ = fmt.Fprintf(os.Stderr, "[SSHTunnel] Server dial error: %s\n", err)
 		return
 	}
+	defer serverConn.Close()
 
 	remoteConn, err := serverConn.Dial("tcp", tunnel.Remote.String())
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "[SSHTunnel] Remote dial error: %s\n", err)
 		return
 	}
+	defer remoteConn.Close()
 
+	done := make(chan struct{}, 2)
 	copyConn := func(writer, reader net.Conn) {
 		_, err1 := io.Copy(writer, reader)
-		if err1 != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "[SSHTunnel] io.Copy error: %s", err1)
+		if err1 != nil && !errors.Is(err1, net.ErrClosed) {
+			_, _ = fmt.Fprintf(os.Stderr, "[SSHTunnel] io.Copy error: %s\n", err1)
 		}
+		done <- struct{}{}
 	}
 
 	go copyConn(localConn, remoteConn)
 	go copyConn(remoteConn, localConn)
+	// 任一方向结束即关闭两端
+	<-done
 }
 
-// SSHClient SSH 客户端。
+// SSHClient SSH 客户端。底层连接、执行及传输复用 RichSSHClient 实现。
 type SSHClient struct {
 	// RSA 私钥证书路径或全文内容
 	PrivateKey string
@@ -127,8 +160,11 @@ type SSHClient struct {
 	Connected bool
 	// SSH 客户端实例
 	Client *ssh.Client
-	// 代理服务器地址 (支持 http,https,socks5,socks5h, 例如: http://127.0.0.1:3128, socks5://127.0.0.1:1080)
+	// 代理服务器地址 (支持 http,https,socks5,socks5h, 例如: http://127.0.0.1:3128, socks5://127.0.0.1:1080; 多个地址以逗号分隔时按顺序串联)
 	Proxy string
+	// 跳板机地址及端口
+	JumpHost string
+	JumpPort int
 	// 超时时间 (默认不超时)
 	Timeout time.Duration
 	// 开启 TTY 终端模式
@@ -139,6 +175,11 @@ type SSHClient struct {
 	Tunnel *SSHTunnel
 	// Logger 接口对象
 	Logger glog.Logger
+
+	commandHooks
+
+	mu   sync.Mutex
+	rich *RichSSHClient
 }
 
 type SSHClientOption func(*SSHClient)
@@ -180,6 +221,28 @@ func SSHOptionWithLogger(logger glog.Logger) SSHClientOption {
 	}
 }
 
+// SSHOptionWithBeforeCommandHook 命令执行前回调，返回错误时拒绝执行该命令。
+func SSHOptionWithBeforeCommandHook(hook BeforeCommandHook) SSHClientOption {
+	return func(c *SSHClient) {
+		c.beforeHooks = append(c.beforeHooks, hook)
+	}
+}
+
+// SSHOptionWithAfterCommandHook 命令执行后回调 (含退出码、耗时及输出字节数)。
+func SSHOptionWithAfterCommandHook(hook AfterCommandHook) SSHClientOption {
+	return func(c *SSHClient) {
+		c.afterHooks = append(c.afterHooks, hook)
+	}
+}
+
+// SSHOptionWithJumpHost 通过跳板机连接目标主机。
+func SSHOptionWithJumpHost(host string, port int) SSHClientOption {
+	return func(c *SSHClient) {
+		c.JumpHost = host
+		c.JumpPort = port
+	}
+}
+
 func NewSSHClient(host string, port int, user string, privateKey string, quiet bool, opts ...SSHClientOption) *SSHClient {
 	c := &SSHClient{
 		Host:       host,
@@ -197,32 +260,15 @@ func NewSSHClient(host string, port int, user string, privateKey string, quiet b
 	return c
 }
 
-func newSSHClientWithProxy(proxyAddress, sshServerAddress string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
-	// dialer, err := proxy.SOCKS5("tcp", proxyAddress, nil, proxy.Direct)
-	proxyURL, err := url.Parse(proxyAddress)
-	if err != nil {
-		return nil, errors.Wrapf(err, "newSSHClientWithProxy url.Parse %s failed", proxyAddress)
-	}
-
-	dialer, err := proxy.FromURL(proxyURL, proxy.Direct)
-	if err != nil {
-		return nil, errors.Wrapf(err, "newSSHClientWithProxy proxy.FromURL %s failed", proxyAddress)
-	}
-
-	conn, err := dialer.Dial("tcp", sshServerAddress)
-	if err != nil {
-		return nil, errors.Wrapf(err, "newSSHClientWithProxy Dial %s failed", sshServerAddress)
-	}
-
-	c, chans, reqs, err := ssh.NewClientConn(conn, sshServerAddress, sshConfig)
-	if err != nil {
-		return nil, errors.Wrapf(err, "newSSHClientWithProxy NewClientConn %s failed", sshServerAddress)
-	}
-
-	return ssh.NewClient(c, chans, reqs), nil
+func (s *SSHClient) Connect() error {
+	return s.ConnectContext(context.Background())
 }
 
-func (s *SSHClient) Connect() error {
+// ConnectContext 建立连接 (支持代理、跳板机及 SSH 隧道)。
+func (s *SSHClient) ConnectContext(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.Connected {
 		return nil
 	}
@@ -230,7 +276,6 @@ func (s *SSHClient) Connect() error {
 		return errors.New("at least one of the plaintext password or RSA key must be set")
 	}
 
-	// var hostKey ssh.PublicKey
 	var key []byte
 
 	if strings.HasPrefix(s.PrivateKey, "~/") || strings.HasPrefix(s.PrivateKey, "/") {
@@ -244,7 +289,7 @@ func (s *SSHClient) Connect() error {
 			return errors.Wrapf(err, "Unable to read private key %s", s.PrivateKey)
 		}
 	}
-	if len(key) == 0 {
+	if len(key) == 0 && s.PrivateKey != "" {
 		if len(s.PrivateKey) < 256 {
 			return errors.New("Invalid private key string")
 		}
@@ -252,40 +297,47 @@ func (s *SSHClient) Connect() error {
 		key = []byte(s.PrivateKey)
 	}
 
-	var authMethods []ssh.AuthMethod
-	var err error
-	var signer ssh.Signer
-
+	opts := []RichSSHClientOption{
+		WithDialTimeout(s.Timeout),
+		WithProxyURL(s.Proxy),
+		WithPTY(s.TTY),
+	}
 	if len(key) > 0 {
-		signer, err = ssh.ParsePrivateKey(key)
-		if err != nil {
-			return errors.Wrapf(err, "Ubable to parse private key %s", s.PrivateKey)
-		}
-
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
+		opts = append(opts, WithPrivateKeyPEM(key))
 	} else {
-		if s.Password != "" {
-			authMethods = append(authMethods, ssh.Password(s.Password))
-		}
+		opts = append(opts, WithPassword(s.Password))
 	}
-
-	config := &ssh.ClientConfig{
-		User: s.User,
-		Auth: authMethods,
-		// HostKeyCallback: ssh.FixedHostKey(hostKey),
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         s.Timeout,
+	if s.JumpHost != "" {
+		opts = append(opts, WithJumpHost(s.JumpHost, s.JumpPort))
 	}
 
 	// 检查 SSH 隧道配置 ...
 	if s.Tunnel != nil {
+		var authMethods []ssh.AuthMethod
+		if len(key) > 0 {
+			signer, err := ssh.ParsePrivateKey(key)
+			if err != nil {
+				return errors.Wrapf(err, "Ubable to parse private key %s", s.PrivateKey)
+			}
+			authMethods = append(authMethods, ssh.PublicKeys(signer))
+		} else {
+			authMethods = append(authMethods, ssh.Password(s.Password))
+		}
+
 		opened := make(chan bool)
 
-		s.Tunnel.Config = config
+		s.Tunnel.Config = &ssh.ClientConfig{
+			User:            s.User,
+			Auth:            authMethods,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         s.Timeout,
+		}
 
 		go s.Tunnel.Start(opened)
 
-		<-opened
+		if !<-opened {
+			return errors.Errorf("SSH tunnel listen on %s failed", s.Tunnel.Local.String())
+		}
 
 		// 若指定端口为0, 则重新读取本地端口号.
 		if s.Port == 0 {
@@ -293,15 +345,11 @@ func (s *SSHClient) Connect() error {
 		}
 	}
 
-	var client *ssh.Client
+	rich := NewRichSSHClient(s.Host, s.Port, s.User, opts...)
+	rich.commandHooks = s.commandHooks
 
-	if s.Proxy != "" {
-		client, err = newSSHClientWithProxy(s.Proxy, fmt.Sprintf("%s:%d", s.Host, s.Port), config)
-	} else {
-		client, err = ssh.Dial("tcp", fmt.Sprintf("%s:%d", s.Host, s.Port), config)
-	}
-
-	if err != nil {
+	if err := rich.Connect(ctx); err != nil {
+		rich.Close()
 		return errors.Wrapf(err, "Unable to connect %s:%d", s.Host, s.Port)
 	}
 
@@ -309,135 +357,160 @@ func (s *SSHClient) Connect() error {
 		glog.Infof("[SSH] Connected to host %s:%d", s.Host, s.Port)
 	}
 
-	s.Client = client
+	s.rich = rich
+	s.Client = rich.client
 	s.Connected = true
 
 	return nil
 }
 
 func (s *SSHClient) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.Connected {
 		if s.Tunnel != nil {
 			s.Tunnel.Stop()
 		}
 
-		err := s.Client.Close()
-		if err != nil {
-			return errors.Wrap(err, "SSHClient Close failed")
-		}
+		s.rich.Close()
+		s.rich = nil
+		s.Client = nil
+		s.Connected = false
 	}
 
 	return nil
 }
 
-func (s *SSHClient) Run(command string) (int, error) {
-	return s.RunWithWriter(command, nil)
-}
-
-func (s *SSHClient) RunWithWriter(command string, w io.Writer) (int, error) {
-	err := s.Connect()
-	if err != nil {
-		return -1, errors.Wrap(err, "SSHClient RunWithWriter Connect failed")
+// richClient 返回已连接的 RichSSHClient。
+func (s *SSHClient) richClient(ctx context.Context) (*RichSSHClient, error) {
+	if err := s.ConnectContext(ctx); err != nil {
+		return nil, err
 	}
 
-	session, err := s.Client.NewSession()
-	if err != nil {
-		return -2, errors.Wrap(err, "SSHClient RunWithWriter NewSession failed")
-	}
-
-	if s.TTY {
-		// Set up terminal modes
-		modes := ssh.TerminalModes{
-			ssh.ECHO:          1,
-			ssh.TTY_OP_ISPEED: 14400,
-			ssh.TTY_OP_OSPEED: 14400,
-		}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		err = session.RequestPty("xterm", 24, 80, modes)
-		if err != nil {
-			return -2, errors.Wrapf(err, "failed to set tty (%s:%d)", s.Host, s.Port)
-		}
+	if s.rich == nil {
+		return nil, errors.New("SSHClient closed")
 	}
 
-	defer func() {
-		err1 := session.Close()
-		if err1 != nil {
-			glog.Errorf("session.Close() error: %v", err1)
-		}
-	}()
+	return s.rich, nil
+}
 
-	stderr, _ := session.StderrPipe()
-	stdout, _ := session.StdoutPipe()
+func (s *SSHClient) Run(command string) (int, error) {
+	return s.RunContext(context.Background(), command)
+}
 
-	if err = session.Start(command); err != nil {
-		return -3, errors.Wrap(err, "SSHClient RunWithWriter session Start failed")
-	}
+// RunContext 执行命令，ctx 结束时终止远程命令。
+func (s *SSHClient) RunContext(ctx context.Context, command string) (int, error) {
+	return s.RunWithWriterContext(ctx, command, nil)
+}
 
-	var scanner *bufio.Scanner
+func (s *SSHClient) RunWithWriter(command string, w io.Writer) (int, error) {
+	return s.RunWithWriterContext(context.Background(), command, w)
+}
 
-	if w != nil {
-		scanner = bufio.NewScanner(io.TeeReader(io.MultiReader(stdout, stderr), w))
-	} else {
-		scanner = bufio.NewScanner(io.MultiReader(stdout, stderr))
+// RunWithWriterContext 执行命令，标准输出及标准错误同时写入 w (可为 nil)，并按行输出到 Logger (非静默方式时)。
+func (s *SSHClient) RunWithWriterContext(ctx context.Context, command string, w io.Writer) (int, error) {
+	rich, err := s.richClient(ctx)
+	if err != nil {
+		return -1, errors.Wrap(err, "SSHClient RunWithWriter Connect failed")
 	}
 
-	for scanner.Scan() {
-		m := scanner.Text()
-
-		if !s.Quiet {
-			if s.Logger != nil {
-				s.Logger.Info(m)
-			} else {
-				_, _ = fmt.Fprintln(os.Stdout, m)
-			}
+	var mu sync.Mutex
+	stdout := &lineWriter{mu: &mu, w: w, emit: func(line string) {
+		if s.Logger != nil {
+			s.Logger.Info(line)
+		} else {
+			_, _ = fmt.Fprintln(os.Stdout, line)
 		}
+	}}
+	stderr := &lineWriter{mu: &mu, w: w, emit: func(line string) {
+		if s.Logger != nil {
+			s.Logger.Warn(line)
+		} else {
+			_, _ = fmt.Fprintln(os.Stderr, line)
+		}
+	}}
+	if s.Quiet {
+		stdout.emit, stderr.emit = nil, nil
 	}
 
-	if err = session.Wait(); err != nil {
+	err = rich.RunStream(ctx, command, stdout, stderr)
+	stdout.Flush()
+	stderr.Flush()
+
+	if err != nil {
 		var exiterr *ssh.ExitError
 		if errors.As(err, &exiterr) {
 			// The program has exited with an exit code != 0
-
-			// This works on both Unix and Windows. Although package
-			// syscall is generally platform dependent, WaitStatus is
-			// defined for both Unix and Windows and in both cases has
-			// an ExitStatus() method with the same signature.
 			exitstatus := exiterr.ExitStatus()
 
 			return exitstatus, errors.Wrapf(err, "SSHClient RunWithWriter Session Wait failed #%d", exitstatus)
 		}
+
+		return -3, errors.Wrap(err, "SSHClient RunWithWriter failed")
 	}
 
 	return 0, nil
 }
 
-func (s *SSHClient) Upload(src, dst string) error {
-	err := s.Connect()
-	if err != nil {
-		return errors.Wrap(err, "SSHClient Upload Connect failed")
-	}
+// lineWriter 将写入内容同时转发到 w，并按行回调 emit。
+type lineWriter struct {
+	mu   *sync.Mutex
+	w    io.Writer
+	emit func(line string)
+	buf  []byte
+}
 
-	sftpClient, err := sftp.NewClient(s.Client)
-	if err != nil {
-		return errors.Wrap(err, "SSHClient Upload sftp.NewClient failed")
+func (l *lineWriter) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.w != nil {
+		if _, err := l.w.Write(b); err != nil {
+			return 0, err
+		}
 	}
-	defer func() {
-		err1 := sftpClient.Close()
-		if err1 != nil {
-			glog.Error(err1)
+	if l.emit == nil {
+		return len(b), nil
+	}
+
+	l.buf = append(l.buf, b...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
 		}
-	}()
+		l.emit(strings.TrimSuffix(string(l.buf[:i]), "\r"))
+		l.buf = l.buf[i+1:]
+	}
 
-	dstFile, err := sftpClient.Create(dst)
+	return len(b), nil
+}
+
+// Flush 输出最后不完整的一行。
+func (l *lineWriter) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.emit != nil && len(l.buf) > 0 {
+		l.emit(string(l.buf))
+	}
+	l.buf = nil
+}
+
+func (s *SSHClient) Upload(src, dst string) error {
+	return s.UploadContext(context.Background(), src, dst)
+}
+
+// UploadContext 上传本地文件 src 到远程 dst，ctx 结束时中止传输。SFTP 不可用时自动使用 SCP。
+func (s *SSHClient) UploadContext(ctx context.Context, src, dst string) error {
+	rich, err := s.richClient(ctx)
 	if err != nil {
-		return errors.Wrapf(err, "SSHClient Upload sftp.Create %s failed", dst)
+		return errors.Wrap(err, "SSHClient Upload Connect failed")
 	}
-	defer func() {
-		err1 := dstFile.Close()
-		if err1 != nil {
-			glog.Error(err1)
-		}
-	}()
 
 	srcFile, err := os.Open(src)
 	if err != nil {
@@ -455,36 +528,32 @@ func (s *SSHClient) Upload(src, dst string) error {
 		return errors.Wrapf(err, "SSHClient Upload os.Stat %s failed", src)
 	}
 
-	totalByteCount := fileInfo.Size()
-	readByteCount := 0
-
-	buf := make([]byte, s.ChunkSize)
-
 	isTty := term.IsTerminal(int(os.Stdout.Fd()))
 
-	for {
-		n, err1 := srcFile.Read(buf)
+	scp, err := rich.useSCP()
+	if err != nil {
+		return errors.Wrap(err, "SSHClient Upload failed")
+	}
+	if scp {
+		err = rich.SCPUpload(ctx, src, dst, false, nil)
+		if err != nil {
+			return errors.Wrapf(err, "SSHClient Upload scp %s failed", dst)
+		}
+	} else {
+		dstFile, err1 := rich.sftpClient.Create(dst)
 		if err1 != nil {
-			if err1 != io.EOF {
-				return errors.Wrap(err1, "SSHClient Upload srcFile Read EOF")
-			}
-			readByteCount = readByteCount + n
-
-			_, _ = dstFile.Write(buf[:n])
-
-			if isTty && !s.Quiet {
-				fmt.Printf("\r%.2f%%", float32(readByteCount)*100/float32(totalByteCount))
-			}
-			// logger.Debugf("readByteCount=%d, n=%d, %v", readByteCount, n, err)
-			break
+			return errors.Wrapf(err1, "SSHClient Upload sftp.Create %s failed", dst)
 		}
+		defer func() {
+			err2 := dstFile.Close()
+			if err2 != nil {
+				glog.Error(err2)
+			}
+		}()
 
-		readByteCount = readByteCount + n
-
-		_, _ = dstFile.Write(buf[:n])
-
-		if isTty && !s.Quiet {
-			fmt.Printf("\r%.2f%%", float32(readByteCount)*100/float32(totalByteCount))
+		err = s.copyChunks(ctx, dstFile, srcFile, fileInfo.Size(), isTty && !s.Quiet)
+		if err != nil {
+			return errors.Wrap(err, "SSHClient Upload failed")
 		}
 	}
 
@@ -492,97 +561,103 @@ func (s *SSHClient) Upload(src, dst string) error {
 		glog.Infof("Uploaded. (%s -> %s)", src, dst)
 	}
 
-	// s.Run(fmt.Sprintf("ls -lh %s", dst))
-
 	return nil
 }
 
 func (s *SSHClient) Download(src, dst string) error {
-	err := s.Connect()
+	return s.DownloadContext(context.Background(), src, dst)
+}
+
+// DownloadContext 下载远程文件 src 到本地 dst，ctx 结束时中止传输。SFTP 不可用时自动使用 SCP。
+func (s *SSHClient) DownloadContext(ctx context.Context, src, dst string) error {
+	rich, err := s.richClient(ctx)
 	if err != nil {
 		return errors.Wrap(err, "SSHClient Download Connect failed")
 	}
 
-	sftpClient, err := sftp.NewClient(s.Client)
+	isTty := term.IsTerminal(int(os.Stdout.Fd()))
+
+	scp, err := rich.useSCP()
 	if err != nil {
-		return errors.Wrap(err, "SSHClient Download sftp.NewClient failed")
+		return errors.Wrap(err, "SSHClient Download failed")
 	}
-	defer func() {
-		err1 := sftpClient.Close()
+	if scp {
+		err = rich.SCPDownload(ctx, src, dst, false, nil)
+		if err != nil {
+			return errors.Wrapf(err, "SSHClient Download scp %s failed", src)
+		}
+	} else {
+		srcFile, err1 := rich.sftpClient.Open(src)
 		if err1 != nil {
-			glog.Error(err1)
+			return errors.Wrapf(err1, "SSHClient Download sftp.Open %s failed", src)
 		}
-	}()
+		defer func() {
+			err2 := srcFile.Close()
+			if err2 != nil {
+				glog.Error(err2)
+			}
+		}()
 
-	srcFile, err := sftpClient.Open(src)
-	if err != nil {
-		return errors.Wrapf(err, "SSHClient Download sftp.Open %s failed", src)
-	}
-	defer func() {
-		err1 := srcFile.Close()
+		srcFileInfo, err1 := srcFile.Stat()
 		if err1 != nil {
-			glog.Error(err1)
+			return errors.Wrapf(err1, "SSHClient Download sftp.Stat %s failed", src)
 		}
-	}()
 
-	srcFileInfo, err := srcFile.Stat()
-	if err != nil {
-		return errors.Wrapf(err, "SSHClient Download sftp.Stat %s failed", src)
-	}
-
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return errors.Wrapf(err, "SSHClient Download os.Create %s failed", dst)
-	}
-	defer func() {
-		err1 := dstFile.Close()
+		dstFile, err1 := os.Create(dst)
 		if err1 != nil {
-			glog.Error(err1)
+			return errors.Wrapf(err1, "SSHClient Download os.Create %s failed", dst)
 		}
-	}()
+		defer func() {
+			err2 := dstFile.Close()
+			if err2 != nil {
+				glog.Error(err2)
+			}
+		}()
+
+		err = s.copyChunks(ctx, dstFile, srcFile, srcFileInfo.Size(), isTty && !s.Quiet)
+		if err != nil {
+			return errors.Wrap(err, "SSHClient Download failed")
+		}
+	}
 
-	totalByteCount := srcFileInfo.Size()
-	readByteCount := 0
+	if isTty && !s.Quiet {
+		glog.Infof("Downloaded. (%s -> %s)", src, dst)
+	}
 
-	fmt.Println(totalByteCount)
+	return nil
+}
 
-	buf := make([]byte, s.ChunkSize)
+// copyChunks 按 ChunkSize 分块复制，showProgress 时在终端输出百分比进度。
+func (s *SSHClient) copyChunks(ctx context.Context, dst io.Writer, src io.Reader, totalByteCount int64, showProgress bool) error {
+	chunkSize := int(s.ChunkSize)
+	if chunkSize == 0 {
+		chunkSize = 8192
+	}
+	buf := make([]byte, chunkSize)
 
-	isTty := term.IsTerminal(int(os.Stdout.Fd()))
+	var readByteCount int64
 
 	for {
-		n, err1 := srcFile.Read(buf)
-		if err1 != nil {
-			if err1 != io.EOF {
-				return errors.Wrap(err1, "SSHClient Download failed (EOF)")
-			}
-			readByteCount = readByteCount + n
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-			_, _ = dstFile.Write(buf[:n])
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, err1 := dst.Write(buf[:n]); err1 != nil {
+				return err1
+			}
+			readByteCount += int64(n)
 
-			if isTty && !s.Quiet {
+			if showProgress && totalByteCount > 0 {
 				fmt.Printf("\r%.2f%%", float32(readByteCount)*100/float32(totalByteCount))
 			}
-			// logger.Debugf("readByteCount=%d, n=%d, %v", readByteCount, n, err)
-			break
 		}
-
-		readByteCount = readByteCount + n
-
-		_, _ = dstFile.Write(buf[:n])
-
-		if isTty && !s.Quiet {
-			fmt.Printf("\r%.2f%%", float32(readByteCount)*100/float32(totalByteCount))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 	}
-
-	// if _, err := srcFile.WriteTo(dstFile); err != nil {
-	// 	return err
-	// }
-
-	if isTty && !s.Quiet {
-		glog.Infof("Downloaded. (%s -> %s)", src, dst)
-	}
-
-	return nil
 }
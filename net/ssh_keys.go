@@ -0,0 +1,199 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// KeyType is an SSH key algorithm for GenerateKey.
+type KeyType string
+
+const (
+	KeyTypeEd25519 KeyType = "ed25519"
+	KeyTypeECDSA   KeyType = "ecdsa"
+	KeyTypeRSA     KeyType = "rsa"
+)
+
+// KeyGenOption controls GenerateKey.
+type KeyGenOption struct {
+	// Bits is the RSA modulus size (default 3072, at least 2048) or the ECDSA curve size
+	// (256, 384 or 521; default 256). Ed25519 ignores it.
+	Bits int
+	// Passphrase encrypts the private key when set.
+	Passphrase string
+	// Comment is stored in both keys, e.g. "deploy@ci".
+	Comment string
+}
+
+// KeyPair is a generated key in OpenSSH formats.
+type KeyPair struct {
+	PrivateKey  []byte // "OPENSSH PRIVATE KEY" PEM
+	PublicKey   []byte // authorized_keys line, ending in "\n"
+	Fingerprint string // SHA256:...
+}
+
+// GenerateKey creates a key pair like ssh-keygen. opt may be nil.
+func GenerateKey(keyType KeyType, opt *KeyGenOption) (*KeyPair, error) {
+	if opt == nil {
+		opt = &KeyGenOption{}
+	}
+
+	var priv crypto.Signer
+	switch keyType {
+	case KeyTypeEd25519:
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		priv = k
+	case KeyTypeECDSA:
+		var curve elliptic.Curve
+		switch opt.Bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("invalid ecdsa key size %d", opt.Bits)
+		}
+		k, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		priv = k
+	case KeyTypeRSA:
+		bits := opt.Bits
+		if bits == 0 {
+			bits = 3072
+		}
+		if bits < 2048 {
+			return nil, fmt.Errorf("rsa key size %d is too small", bits)
+		}
+		k, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
+		priv = k
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", keyType)
+	}
+
+	var (
+		block *pem.Block
+		err   error
+	)
+	if opt.Passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, opt.Comment, []byte(opt.Passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(priv, opt.Comment)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal private key failed")
+	}
+
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+	line := bytes.TrimSuffix(ssh.MarshalAuthorizedKey(pub), []byte("\n"))
+	if opt.Comment != "" {
+		line = append(append(line, ' '), opt.Comment...)
+	}
+	return &KeyPair{
+		PrivateKey:  pem.EncodeToMemory(block),
+		PublicKey:   append(line, '\n'),
+		Fingerprint: ssh.FingerprintSHA256(pub),
+	}, nil
+}
+
+// WriteFiles saves the private key to path (mode 0600) and the public key to path+".pub" (0644).
+func (k *KeyPair) WriteFiles(path string) error {
+	if err := os.WriteFile(path, k.PrivateKey, 0o600); err != nil {
+		return errors.Wrapf(err, "write %s failed", path)
+	}
+	if err := os.WriteFile(path+".pub", k.PublicKey, 0o644); err != nil {
+		return errors.Wrapf(err, "write %s.pub failed", path)
+	}
+	return nil
+}
+
+// Fingerprint returns the SHA256 fingerprint of an authorized_keys line or a private key PEM
+// (which must not be encrypted).
+func Fingerprint(key []byte) (string, error) {
+	if pub, _, _, _, err := ssh.ParseAuthorizedKey(key); err == nil {
+		return ssh.FingerprintSHA256(pub), nil
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return "", errors.New("neither a public nor an unencrypted private key")
+	}
+	return ssh.FingerprintSHA256(signer.PublicKey()), nil
+}
+
+var unixUserRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*\$?$`)
+
+// InstallAuthorizedKey adds publicKey (an authorized_keys line) to ~/.ssh/authorized_keys of user,
+// or of the login user when user is empty, like ssh-copy-id. The directory gets mode 0700 and the
+// file 0600; a key already present (compared by key data, not comment) is not added again.
+// Installing for another user needs root and chowns the directory to that user.
+func (c *RichSSHClient) InstallAuthorizedKey(ctx context.Context, publicKey []byte, user string) (changed bool, err error) {
+	pub, comment, options, _, err := ssh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		return false, errors.Wrap(err, "invalid public key")
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	blob := strings.Fields(line)[1]
+	if len(options) > 0 {
+		line = strings.Join(options, ",") + " " + line
+	}
+	if comment != "" {
+		line += " " + comment
+	}
+
+	dir := `"$HOME/.ssh"`
+	if user != "" {
+		if !unixUserRe.MatchString(user) {
+			return false, fmt.Errorf("invalid user name %q", user)
+		}
+		// unquoted so the shell expands ~user
+		dir = "~" + user + "/.ssh"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "set -e; umask 077; d=%s; f=\"$d/authorized_keys\"\n", dir)
+	sb.WriteString("mkdir -p \"$d\"; chmod 700 \"$d\"; touch \"$f\"; chmod 600 \"$f\"\n")
+	if user != "" {
+		fmt.Fprintf(&sb, "chown %s: \"$d\" \"$f\"\n", user)
+	}
+	fmt.Fprintf(&sb, "if awk -v b=%s '{for(i=1;i<=NF;i++) if($i==b) f=1} END{exit !f}' \"$f\"; then echo present; exit 0; fi\n", shellQuote(blob))
+	sb.WriteString("if [ -s \"$f\" ] && [ -n \"$(tail -c 1 \"$f\")\" ]; then echo >> \"$f\"; fi\n")
+	fmt.Fprintf(&sb, "printf '%%s\\n' %s >> \"$f\"\n", shellQuote(line))
+	sb.WriteString("command -v restorecon >/dev/null 2>&1 && restorecon -R \"$d\" >/dev/null 2>&1 || true\n")
+	sb.WriteString("echo added")
+
+	resp, err := c.Run(ctx, sb.String())
+	if err != nil {
+		return false, errors.Wrap(err, "install authorized key failed")
+	}
+	if resp.ExitCode != 0 {
+		return false, fmt.Errorf("install authorized key failed with exit code %d: %s", resp.ExitCode, strings.TrimSpace(string(resp.Stderr)))
+	}
+	return strings.TrimSpace(string(resp.Stdout)) == "added", nil
+}
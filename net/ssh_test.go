@@ -0,0 +1,196 @@
+package net_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	gonet "net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/designinlife/slib/net"
+	"github.com/designinlife/slib/net/sshtest"
+)
+
+func TestSSHClient(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	srv := newTestServer(t, sshtest.WithAuthorizedKey("tester", sshPub))
+
+	c := net.NewSSHClient(srv.Host, srv.Port, "tester", string(pem.EncodeToMemory(block)), true)
+	defer c.Close()
+
+	var out bytes.Buffer
+	code, err := c.RunWithWriter("echo hello", &out)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "hello\n", out.String())
+
+	code, err = c.Run("exit 2")
+	assert.Error(t, err)
+	assert.Equal(t, 2, code)
+
+	local := filepath.Join(t.TempDir(), "up.txt")
+	require.NoError(t, os.WriteFile(local, []byte("payload"), 0o644))
+	remote := filepath.Join(srv.Root, "up.txt")
+	require.NoError(t, c.Upload(local, remote))
+
+	back := filepath.Join(t.TempDir(), "down.txt")
+	require.NoError(t, c.Download(remote, back))
+	b, err := os.ReadFile(back)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(b))
+}
+
+func TestSSHClientPasswordJumpAndClose(t *testing.T) {
+	jump := newTestServer(t)
+	srv := newTestServer(t)
+
+	c := net.NewSSHClient(srv.Host, srv.Port, "tester", "", true,
+		net.SSHOptionWithPassword("secret"),
+		net.SSHOptionWithJumpHost(jump.Host, jump.Port),
+	)
+
+	var out bytes.Buffer
+	code, err := c.RunWithWriterContext(context.Background(), "echo out; echo err >&2", &out)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out.String(), "out\n")
+	assert.Contains(t, out.String(), "err\n")
+	assert.True(t, c.Connected)
+
+	require.NoError(t, c.Close())
+	assert.False(t, c.Connected)
+	assert.Nil(t, c.Client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = c.RunContext(ctx, "sleep 5")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestSSHClientTunnel(t *testing.T) {
+	srv := newTestServer(t)
+
+	// the tunnel reaches the server itself through a direct-tcpip channel of a first connection
+	tunnel := &net.SSHTunnel{
+		Local:  &net.SSHTunnelEndpoint{Host: "127.0.0.1"},
+		Server: &net.SSHTunnelEndpoint{Host: srv.Host, Port: srv.Port},
+		Remote: &net.SSHTunnelEndpoint{Host: srv.Host, Port: srv.Port},
+	}
+	c := net.NewSSHClient("127.0.0.1", 0, "tester", "", true,
+		net.SSHOptionWithPassword("secret"),
+		net.SSHOptionWithTunnel(tunnel),
+	)
+
+	var out bytes.Buffer
+	code, err := c.RunWithWriter("echo tunneled", &out)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "tunneled\n", out.String())
+	assert.NotZero(t, tunnel.Local.Port)
+	assert.Equal(t, tunnel.Local.Port, c.Port)
+
+	require.NoError(t, c.Close())
+	tunnel.Stop()
+	assert.Eventually(t, func() bool {
+		conn, err1 := gonet.Dial("tcp", tunnel.Local.String())
+		if err1 == nil {
+			_ = conn.Close()
+		}
+		return err1 != nil
+	}, 2*time.Second, 20*time.Millisecond, "the tunnel stops listening")
+
+	// stopping a tunnel that never started does not panic
+	(&net.SSHTunnel{}).Stop()
+}
+
+func TestServerForwarding(t *testing.T) {
+	echo, err := gonet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err1 := echo.Accept()
+			if err1 != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	roundTrip := func(t *testing.T, conn gonet.Conn) {
+		t.Helper()
+		defer conn.Close()
+		_, err1 := conn.Write([]byte("ping\n"))
+		require.NoError(t, err1)
+		line, err1 := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err1)
+		assert.Equal(t, "ping\n", line)
+	}
+
+	srv := newTestServer(t)
+	client := sshClientOf(t, srv)
+
+	// direct-tcpip: the server dials the echo server for us
+	conn, err := client.Dial("tcp", echo.Addr().String())
+	require.NoError(t, err)
+	roundTrip(t, conn)
+
+	// tcpip-forward: the server listens and hands connections back as forwarded-tcpip channels
+	l, err := client.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err1 := l.Accept()
+			if err1 != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	conn, err = gonet.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	roundTrip(t, conn)
+	require.NoError(t, l.Close())
+
+	off := newTestServer(t, sshtest.WithoutForwarding())
+	client = sshClientOf(t, off)
+	_, err = client.Dial("tcp", echo.Addr().String())
+	assert.Error(t, err)
+	_, err = client.Listen("tcp", "127.0.0.1:0")
+	assert.Error(t, err)
+}
+
+// sshClientOf connects a plain ssh.Client to srv as the test user.
+func sshClientOf(t *testing.T, srv *sshtest.Server) *ssh.Client {
+	t.Helper()
+	client, err := ssh.Dial("tcp", srv.Addr, &ssh.ClientConfig{
+		User:            "tester",
+		Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+		HostKeyCallback: ssh.FixedHostKey(srv.HostKey.PublicKey()),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
@@ -0,0 +1,91 @@
+package sshtest
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DialPipe returns the client end of an in-memory connection served by s. Unlike net.Pipe, writes are
+// buffered, so both sides can send their SSH version line at the same time.
+func (s *Server) DialPipe() net.Conn {
+	a, b := bufferedPipe()
+	s.ServeConn(b)
+	return a
+}
+
+// pipeBuffer is one direction of a buffered pipe.
+type pipeBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newPipeBuffer() *pipeBuffer {
+	p := &pipeBuffer{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *pipeBuffer) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.buf.Len() == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if p.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return p.buf.Read(b)
+}
+
+func (p *pipeBuffer) write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	p.cond.Broadcast()
+	return p.buf.Write(b)
+}
+
+func (p *pipeBuffer) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+type pipeConn struct {
+	r, w *pipeBuffer
+}
+
+func bufferedPipe() (net.Conn, net.Conn) {
+	ab, ba := newPipeBuffer(), newPipeBuffer()
+	return &pipeConn{r: ba, w: ab}, &pipeConn{r: ab, w: ba}
+}
+
+func (c *pipeConn) Read(b []byte) (int, error)  { return c.r.read(b) }
+func (c *pipeConn) Write(b []byte) (int, error) { return c.w.write(b) }
+
+func (c *pipeConn) Close() error {
+	c.r.close()
+	c.w.close()
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return pipeAddr{} }
+func (c *pipeConn) RemoteAddr() net.Addr { return pipeAddr{} }
+
+// deadlines are not supported by the in-memory pipe and are ignored
+func (c *pipeConn) SetDeadline(time.Time) error      { return nil }
+func (c *pipeConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *pipeConn) SetWriteDeadline(time.Time) error { return nil }
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
@@ -0,0 +1,593 @@
+// Package sshtest provides an in-process SSH server for testing SSH and SFTP clients offline,
+// in the spirit of net/http/httptest.
+package sshtest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// ExecRequest describes a command (or shell, when Command is empty) requested by a client.
+type ExecRequest struct {
+	// Context is cancelled when the client sends a signal or closes the session.
+	Context context.Context
+	User    string
+	Command string
+	Env     []string
+	PTY     bool
+	// Dir is the server root directory.
+	Dir    string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	exitSignal string
+	coreDumped bool
+}
+
+// SetExitSignal makes the server report an exit-signal (a name like "SEGV") instead of the handler's
+// exit status.
+func (r *ExecRequest) SetExitSignal(signal string, coreDumped bool) {
+	r.exitSignal, r.coreDumped = signal, coreDumped
+}
+
+// ExecHandler runs a request and returns its exit status.
+type ExecHandler func(req *ExecRequest) int
+
+// ShellExec is an ExecHandler that runs the command with the local `sh -c` in the server root.
+func ShellExec(req *ExecRequest) int {
+	args := []string{"-c", req.Command}
+	if req.Command == "" {
+		args = nil
+	}
+	cmd := exec.CommandContext(req.Context, "sh", args...)
+	cmd.Dir = req.Dir
+	cmd.Env = append(os.Environ(), req.Env...)
+	cmd.Stdout = req.Stdout
+	cmd.Stderr = req.Stderr
+	// like sshd, do not wait for the client to close stdin once the command exits
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return 255
+	}
+	if err = cmd.Start(); err != nil {
+		return 127
+	}
+	go func() {
+		_, _ = io.Copy(stdin, req.Stdin)
+		_ = stdin.Close()
+	}()
+	if err = cmd.Wait(); err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && ee.ExitCode() >= 0 {
+			return ee.ExitCode()
+		}
+		if ee != nil {
+			if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() && req.Context.Err() == nil {
+				if name, ok1 := signalNames[ws.Signal()]; ok1 {
+					req.SetExitSignal(name, ws.CoreDump())
+				}
+			}
+		}
+		return 255
+	}
+	return 0
+}
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP: "HUP", syscall.SIGINT: "INT", syscall.SIGQUIT: "QUIT", syscall.SIGILL: "ILL",
+	syscall.SIGABRT: "ABRT", syscall.SIGFPE: "FPE", syscall.SIGKILL: "KILL", syscall.SIGSEGV: "SEGV",
+	syscall.SIGPIPE: "PIPE", syscall.SIGALRM: "ALRM", syscall.SIGTERM: "TERM",
+}
+
+// Server is an in-process SSH server listening on a random localhost port.
+type Server struct {
+	// Addr is the listen address, "127.0.0.1:port".
+	Addr string
+	Host string
+	Port int
+	// Root backs the SFTP subsystem (its working directory) and is the ExecRequest.Dir.
+	Root string
+	// HostKey is the server host key, generated on start.
+	HostKey ssh.Signer
+
+	passwords      map[string]string
+	authorizedKeys map[string][]ssh.PublicKey
+	execHandler    ExecHandler
+	disableSFTP    bool
+	disableExec    bool
+	disableForward bool
+	ownRoot        bool
+	configure      []func(cfg *ssh.ServerConfig)
+
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+type Option func(s *Server)
+
+// WithPassword allows user to log in with password.
+func WithPassword(user, password string) Option {
+	return func(s *Server) {
+		s.passwords[user] = password
+	}
+}
+
+// WithAuthorizedKey allows user to log in with the private key matching key.
+func WithAuthorizedKey(user string, key ssh.PublicKey) Option {
+	return func(s *Server) {
+		s.authorizedKeys[user] = append(s.authorizedKeys[user], key)
+	}
+}
+
+// WithExecHandler sets the handler for exec and shell requests. Without it commands exit with 127.
+func WithExecHandler(h ExecHandler) Option {
+	return func(s *Server) {
+		s.execHandler = h
+	}
+}
+
+// WithRoot uses dir as the server root instead of a fresh temp directory.
+func WithRoot(dir string) Option {
+	return func(s *Server) {
+		s.Root = dir
+	}
+}
+
+// WithoutSFTP rejects the sftp subsystem, like servers with sftp-server disabled.
+func WithoutSFTP() Option {
+	return func(s *Server) {
+		s.disableSFTP = true
+	}
+}
+
+// WithoutExec rejects exec and shell requests, like servers that only allow sftp.
+func WithoutExec() Option {
+	return func(s *Server) {
+		s.disableExec = true
+	}
+}
+
+// WithoutForwarding rejects direct-tcpip channels and tcpip-forward requests.
+func WithoutForwarding() Option {
+	return func(s *Server) {
+		s.disableForward = true
+	}
+}
+
+// WithServerConfig lets fn adjust the server config, e.g. to restrict algorithms or set ServerVersion.
+func WithServerConfig(fn func(cfg *ssh.ServerConfig)) Option {
+	return func(s *Server) {
+		s.configure = append(s.configure, fn)
+	}
+}
+
+// NewServer starts a server. When no password or key is configured, any client is accepted.
+func NewServer(opts ...Option) (*Server, error) {
+	s := &Server{
+		passwords:      make(map[string]string),
+		authorizedKeys: make(map[string][]ssh.PublicKey),
+		conns:          make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.Root == "" {
+		dir, err := os.MkdirTemp("", "sshtest-")
+		if err != nil {
+			return nil, errors.Wrap(err, "sshtest create root directory failed")
+		}
+		s.Root = dir
+		s.ownRoot = true
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "sshtest generate host key failed")
+	}
+	s.HostKey, err = ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, errors.Wrap(err, "sshtest create host key signer failed")
+	}
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "sshtest listen failed")
+	}
+	s.Addr = s.listener.Addr().String()
+	host, port, _ := net.SplitHostPort(s.Addr)
+	s.Host = host
+	s.Port, _ = strconv.Atoi(port)
+
+	s.wg.Add(1)
+	go s.serve(s.config())
+
+	return s, nil
+}
+
+func (s *Server) config() *ssh.ServerConfig {
+	cfg := &ssh.ServerConfig{
+		NoClientAuth: len(s.passwords) == 0 && len(s.authorizedKeys) == 0,
+	}
+	if len(s.passwords) > 0 {
+		cfg.PasswordCallback = func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if pw, ok := s.passwords[meta.User()]; ok && pw == string(password) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", meta.User())
+		}
+	}
+	if len(s.authorizedKeys) > 0 {
+		cfg.PublicKeyCallback = func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range s.authorizedKeys[meta.User()] {
+				if string(k.Marshal()) == string(key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown public key for %s", meta.User())
+		}
+	}
+	cfg.AddHostKey(s.HostKey)
+	for _, fn := range s.configure {
+		fn(cfg)
+	}
+	return cfg
+}
+
+// Close stops the server, drops all connections and removes the temp root it created.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.listener.Close()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	if s.ownRoot {
+		_ = os.RemoveAll(s.Root)
+	}
+	return err
+}
+
+// ServeConn serves one SSH connection over an already established conn in the background.
+func (s *Server) ServeConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		_ = conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.handleConn(conn, s.config())
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+}
+
+func (s *Server) serve(cfg *ssh.ServerConfig) {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn, cfg)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+
+	go s.handleGlobalRequests(sconn, reqs)
+
+	for nc := range chans {
+		switch nc.ChannelType() {
+		case "session":
+			go s.handleSession(sconn, nc)
+		case "direct-tcpip":
+			if s.disableForward {
+				_ = nc.Reject(ssh.Prohibited, "port forwarding disabled")
+				continue
+			}
+			go handleDirectTCPIP(nc)
+		default:
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+func (s *Server) handleSession(sconn *ssh.ServerConn, nc ssh.NewChannel) {
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	var (
+		env     []string
+		pty     bool
+		started bool
+		signal  string
+		mu      sync.Mutex
+		done    = make(chan struct{})
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for req := range reqs {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			if ssh.Unmarshal(req.Payload, &kv) == nil {
+				env = append(env, kv.Name+"="+kv.Value)
+			}
+			_ = req.Reply(true, nil)
+		case "pty-req":
+			pty = true
+			_ = req.Reply(true, nil)
+		case "window-change":
+			_ = req.Reply(true, nil)
+		case "signal":
+			var sig struct{ Signal string }
+			_ = ssh.Unmarshal(req.Payload, &sig)
+			mu.Lock()
+			signal = sig.Signal
+			mu.Unlock()
+			cancel()
+			_ = req.Reply(true, nil)
+		case "exec", "shell":
+			if started || s.disableExec {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			var payload struct{ Command string }
+			if req.Type == "exec" {
+				_ = ssh.Unmarshal(req.Payload, &payload)
+			}
+			started = true
+			_ = req.Reply(true, nil)
+
+			execReq := &ExecRequest{
+				Context: ctx,
+				User:    sconn.User(),
+				Command: payload.Command,
+				Env:     env,
+				PTY:     pty,
+				Dir:     s.Root,
+				Stdin:   ch,
+				Stdout:  ch,
+				Stderr:  ch.Stderr(),
+			}
+			go func() {
+				defer close(done)
+				code := 127
+				if s.execHandler != nil {
+					code = s.execHandler(execReq)
+				} else {
+					_, _ = fmt.Fprintf(ch.Stderr(), "sshtest: no exec handler for %q\n", payload.Command)
+				}
+				mu.Lock()
+				sig, core := signal, false
+				mu.Unlock()
+				if execReq.exitSignal != "" {
+					sig, core = execReq.exitSignal, execReq.coreDumped
+				}
+				if sig != "" {
+					_, _ = ch.SendRequest("exit-signal", false, ssh.Marshal(struct {
+						Signal     string
+						CoreDumped bool
+						Error      string
+						Lang       string
+					}{Signal: sig, CoreDumped: core}))
+				} else {
+					_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(code)}))
+				}
+				_ = ch.CloseWrite()
+				_ = ch.Close()
+			}()
+		case "subsystem":
+			var payload struct{ Name string }
+			_ = ssh.Unmarshal(req.Payload, &payload)
+			if started || payload.Name != "sftp" || s.disableSFTP {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			server, err1 := sftp.NewServer(ch, sftp.WithServerWorkingDirectory(s.Root))
+			if err1 != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			started = true
+			_ = req.Reply(true, nil)
+			go func() {
+				defer close(done)
+				_ = server.Serve()
+				_ = server.Close()
+			}()
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+
+	cancel()
+	if started {
+		<-done
+	}
+}
+
+func (s *Server) handleGlobalRequests(sconn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	var (
+		mu        sync.Mutex
+		listeners = make(map[string]net.Listener)
+	)
+	defer func() {
+		mu.Lock()
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+		mu.Unlock()
+	}()
+
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			var payload struct {
+				Addr string
+				Port uint32
+			}
+			if s.disableForward || ssh.Unmarshal(req.Payload, &payload) != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			l, err := net.Listen("tcp", net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))))
+			if err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			port := uint32(l.Addr().(*net.TCPAddr).Port)
+			mu.Lock()
+			listeners[net.JoinHostPort(payload.Addr, strconv.Itoa(int(port)))] = l
+			mu.Unlock()
+			_ = req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+			go acceptForwards(sconn, l, payload.Addr, port)
+		case "cancel-tcpip-forward":
+			var payload struct {
+				Addr string
+				Port uint32
+			}
+			_ = ssh.Unmarshal(req.Payload, &payload)
+			key := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+			mu.Lock()
+			l, ok := listeners[key]
+			delete(listeners, key)
+			mu.Unlock()
+			if ok {
+				_ = l.Close()
+			}
+			_ = req.Reply(ok, nil)
+		default:
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func acceptForwards(sconn *ssh.ServerConn, l net.Listener, addr string, port uint32) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			origin := conn.RemoteAddr().(*net.TCPAddr)
+			ch, reqs, err1 := sconn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+				Addr       string
+				Port       uint32
+				OriginAddr string
+				OriginPort uint32
+			}{addr, port, origin.IP.String(), uint32(origin.Port)}))
+			if err1 != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			pipe(ch, conn)
+		}()
+	}
+}
+
+func handleDirectTCPIP(nc ssh.NewChannel) {
+	var payload struct {
+		DestAddr string
+		DestPort uint32
+		OrigAddr string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &payload); err != nil {
+		_ = nc.Reject(ssh.ConnectionFailed, "invalid direct-tcpip payload")
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.DestAddr, strconv.Itoa(int(payload.DestPort))))
+	if err != nil {
+		_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	pipe(ch, conn)
+}
+
+// pipe copies both directions until either side is done.
+func pipe(ch ssh.Channel, conn net.Conn) {
+	defer ch.Close()
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(ch, conn)
+		_ = ch.CloseWrite()
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, ch)
+		if tc, ok := conn.(*net.TCPConn); ok {
+			_ = tc.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
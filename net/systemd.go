@@ -0,0 +1,152 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// UnitStatus is the state of a systemd unit as reported by `systemctl show`.
+type UnitStatus struct {
+	Name          string
+	Description   string
+	LoadState     string // loaded, not-found, masked, ...
+	ActiveState   string // active, inactive, failed, activating, ...
+	SubState      string // running, exited, dead, ...
+	UnitFileState string // enabled, disabled, static, masked, ...
+	MainPID       int
+	// Properties holds every property of the unit.
+	Properties map[string]string
+}
+
+// Active reports whether the unit is active (or reloading).
+func (u *UnitStatus) Active() bool {
+	return u.ActiveState == "active" || u.ActiveState == "reloading"
+}
+
+// Enabled reports whether the unit starts at boot.
+func (u *UnitStatus) Enabled() bool {
+	return strings.HasPrefix(u.UnitFileState, "enabled") || u.UnitFileState == "alias"
+}
+
+// UnitResult is the outcome of a unit action.
+type UnitResult struct {
+	Unit    string
+	Changed bool
+	Status  *UnitStatus // state after the action
+}
+
+// UnitStatus returns the state of unit.
+func (c *RichSSHClient) UnitStatus(ctx context.Context, unit string) (*UnitStatus, error) {
+	out, err := c.systemctl(ctx, "show", "--no-pager", "--", unit)
+	if err != nil {
+		return nil, err
+	}
+	st := parseSystemctlShow(out)
+	if st.Name == "" {
+		st.Name = unit
+	}
+	return st, nil
+}
+
+func parseSystemctlShow(out string) *UnitStatus {
+	st := &UnitStatus{Properties: make(map[string]string)}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		st.Properties[k] = v
+	}
+	st.Name = st.Properties["Id"]
+	st.Description = st.Properties["Description"]
+	st.LoadState = st.Properties["LoadState"]
+	st.ActiveState = st.Properties["ActiveState"]
+	st.SubState = st.Properties["SubState"]
+	st.UnitFileState = st.Properties["UnitFileState"]
+	st.MainPID, _ = strconv.Atoi(st.Properties["MainPID"])
+	return st
+}
+
+// StartUnit starts unit unless it is already active.
+func (c *RichSSHClient) StartUnit(ctx context.Context, unit string) (*UnitResult, error) {
+	return c.unitAction(ctx, unit, "start", func(st *UnitStatus) bool { return !st.Active() })
+}
+
+// StopUnit stops unit if it is active.
+func (c *RichSSHClient) StopUnit(ctx context.Context, unit string) (*UnitResult, error) {
+	return c.unitAction(ctx, unit, "stop", func(st *UnitStatus) bool {
+		return st.Active() || st.ActiveState == "activating"
+	})
+}
+
+// RestartUnit restarts unit (starting it when stopped); it always reports a change.
+func (c *RichSSHClient) RestartUnit(ctx context.Context, unit string) (*UnitResult, error) {
+	return c.unitAction(ctx, unit, "restart", func(*UnitStatus) bool { return true })
+}
+
+// ReloadUnit reloads the configuration of unit; it always reports a change.
+func (c *RichSSHClient) ReloadUnit(ctx context.Context, unit string) (*UnitResult, error) {
+	return c.unitAction(ctx, unit, "reload", func(*UnitStatus) bool { return true })
+}
+
+// EnableUnit enables unit at boot unless it already is.
+func (c *RichSSHClient) EnableUnit(ctx context.Context, unit string) (*UnitResult, error) {
+	return c.unitAction(ctx, unit, "enable", func(st *UnitStatus) bool { return !st.Enabled() })
+}
+
+// DisableUnit disables unit at boot if it is enabled.
+func (c *RichSSHClient) DisableUnit(ctx context.Context, unit string) (*UnitResult, error) {
+	return c.unitAction(ctx, unit, "disable", func(st *UnitStatus) bool { return st.Enabled() })
+}
+
+// DaemonReload makes systemd reread its unit files.
+func (c *RichSSHClient) DaemonReload(ctx context.Context) error {
+	_, err := c.systemctl(ctx, "daemon-reload")
+	return err
+}
+
+// unitAction runs `systemctl action unit` when needed reports true for the current state.
+func (c *RichSSHClient) unitAction(ctx context.Context, unit, action string, needed func(st *UnitStatus) bool) (*UnitResult, error) {
+	st, err := c.UnitStatus(ctx, unit)
+	if err != nil {
+		return nil, err
+	}
+	if st.LoadState == "not-found" {
+		return nil, fmt.Errorf("unit %s not found", unit)
+	}
+	res := &UnitResult{Unit: unit, Status: st}
+	if !needed(st) {
+		return res, nil
+	}
+	if _, err = c.systemctl(ctx, action, "--", unit); err != nil {
+		return nil, err
+	}
+	res.Changed = true
+	if res.Status, err = c.UnitStatus(ctx, unit); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *RichSSHClient) systemctl(ctx context.Context, args ...string) (string, error) {
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		quoted = append(quoted, shellQuote(a))
+	}
+	cmd := "systemctl " + strings.Join(quoted, " ")
+	resp, err := c.Run(ctx, cmd)
+	if err != nil {
+		return "", errors.Wrapf(err, "%s failed", cmd)
+	}
+	if resp.ExitCode != 0 {
+		return "", fmt.Errorf("%s failed with exit code %d: %s", cmd, resp.ExitCode, strings.TrimSpace(string(resp.Stderr)))
+	}
+	return string(resp.Stdout), nil
+}
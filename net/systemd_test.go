@@ -0,0 +1,89 @@
+package net_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net/sshtest"
+)
+
+// fakeSystemctl keeps unit state as marker files in $FAKE_STATE.
+const fakeSystemctl = `#!/bin/sh
+s=$FAKE_STATE
+case "$1" in
+show)
+	u=$4
+	if [ "$u" = missing.service ]; then printf 'Id=%s\nLoadState=not-found\nActiveState=inactive\n' "$u"; exit 0; fi
+	[ -f "$s/$u.active" ] && a=active || a=inactive
+	[ -f "$s/$u.enabled" ] && e=enabled || e=disabled
+	printf 'Id=%s\nDescription=Fake unit\nLoadState=loaded\nActiveState=%s\nSubState=running\nUnitFileState=%s\nMainPID=42\n' "$u" "$a" "$e" ;;
+start|restart) touch "$s/$3.active" ;;
+stop) rm -f "$s/$3.active" ;;
+enable) touch "$s/$3.enabled" ;;
+disable) rm -f "$s/$3.enabled" ;;
+*) echo "unknown command $1" >&2; exit 1 ;;
+esac
+`
+
+// newFakeToolsServer serves commands with the scripts in tools first on PATH.
+func newFakeToolsServer(t *testing.T, tools map[string]string) *sshtest.Server {
+	t.Helper()
+	bin, state := t.TempDir(), t.TempDir()
+	for name, script := range tools {
+		require.NoError(t, os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755))
+	}
+	return newTestServer(t, sshtest.WithExecHandler(func(req *sshtest.ExecRequest) int {
+		req.Env = append(req.Env, "PATH="+bin+":"+os.Getenv("PATH"), "FAKE_STATE="+state)
+		return sshtest.ShellExec(req)
+	}))
+}
+
+func TestSystemdUnits(t *testing.T) {
+	srv := newFakeToolsServer(t, map[string]string{"systemctl": fakeSystemctl})
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	st, err := c.UnitStatus(ctx, "nginx.service")
+	require.NoError(t, err)
+	assert.Equal(t, "Fake unit", st.Description)
+	assert.False(t, st.Active())
+	assert.Equal(t, 42, st.MainPID)
+	assert.Equal(t, "running", st.Properties["SubState"])
+
+	res, err := c.StartUnit(ctx, "nginx.service")
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	assert.True(t, res.Status.Active())
+	res, err = c.StartUnit(ctx, "nginx.service")
+	require.NoError(t, err)
+	assert.False(t, res.Changed)
+
+	res, err = c.EnableUnit(ctx, "nginx.service")
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	assert.True(t, res.Status.Enabled())
+	res, err = c.DisableUnit(ctx, "nginx.service")
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	res, err = c.DisableUnit(ctx, "nginx.service")
+	require.NoError(t, err)
+	assert.False(t, res.Changed)
+
+	res, err = c.RestartUnit(ctx, "nginx.service")
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	res, err = c.StopUnit(ctx, "nginx.service")
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	assert.False(t, res.Status.Active())
+
+	_, err = c.StartUnit(ctx, "missing.service")
+	assert.ErrorContains(t, err, "not found")
+	_, err = c.ReloadUnit(ctx, "nginx.service")
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/designinlife/slib/errors"
+)
+
+// ReadyStage is a probe stage of RichSSHClient.WaitReady.
+type ReadyStage string
+
+const (
+	ReadyStageTCP     ReadyStage = "tcp"
+	ReadyStageBanner  ReadyStage = "banner"
+	ReadyStageAuth    ReadyStage = "auth"
+	ReadyStageCommand ReadyStage = "command"
+)
+
+// ReadyError is returned by WaitReady when the host is not ready before ctx is done.
+// Stage and Err describe the last failed probe.
+type ReadyError struct {
+	Stage    ReadyStage
+	Attempts int
+	Err      error
+}
+
+func (e *ReadyError) Error() string {
+	return fmt.Sprintf("ssh not ready after %d attempt(s), last failed stage %s: %v", e.Attempts, e.Stage, e.Err)
+}
+
+func (e *ReadyError) Unwrap() error {
+	return e.Err
+}
+
+// WaitReady polls a freshly provisioned host every interval (default 2s) until TCP is reachable,
+// the SSH banner is served, authentication succeeds and, when readyCommand is not empty, readyCommand
+// exits 0 (e.g. `test -f /var/lib/cloud/instance/boot-finished`). The client stays connected on success.
+// With a jump host or a custom transport the TCP and banner stages are covered by the auth stage.
+func (c *RichSSHClient) WaitReady(ctx context.Context, readyCommand string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	var (
+		stage ReadyStage
+		err   error
+	)
+	for attempt := 1; ; attempt++ {
+		if stage, err = c.probeReady(ctx, readyCommand); err == nil {
+			return nil
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &ReadyError{Stage: stage, Attempts: attempt, Err: err}
+		case <-timer.C:
+		}
+	}
+}
+
+// probeReady runs all stages once and returns the first failing one.
+func (c *RichSSHClient) probeReady(ctx context.Context, readyCommand string) (ReadyStage, error) {
+	if c.JumpSSHHost == "" && c.dialContext == nil && c.presetConn == nil {
+		conn, err := c.netDialContextWithProxy(ctx, "tcp", fmt.Sprintf("%s:%d", c.Host, c.Port))
+		if err != nil {
+			return ReadyStageTCP, err
+		}
+		// a zero dial timeout means none; ctx still bounds the read
+		var deadline time.Time
+		if d, ok := ctx.Deadline(); ok {
+			deadline = d
+		}
+		if c.dialTimeout > 0 {
+			if d := time.Now().Add(c.dialTimeout); deadline.IsZero() || d.Before(deadline) {
+				deadline = d
+			}
+		}
+		_ = conn.SetReadDeadline(deadline)
+		stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+		banner, err := bufio.NewReader(conn).ReadString('\n')
+		stop()
+		_ = conn.Close()
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			return ReadyStageBanner, errors.Wrap(err, "read ssh banner failed")
+		}
+		if !strings.HasPrefix(banner, "SSH-") {
+			return ReadyStageBanner, fmt.Errorf("unexpected banner %q", strings.TrimSpace(banner))
+		}
+	}
+
+	if err := c.Connect(ctx); err != nil {
+		return ReadyStageAuth, err
+	}
+
+	if readyCommand == "" {
+		return "", nil
+	}
+	resp, err := c.Run(ctx, readyCommand)
+	if err != nil {
+		return ReadyStageCommand, err
+	}
+	if resp.ExitCode != 0 {
+		return ReadyStageCommand, fmt.Errorf("exit status %d: %s", resp.ExitCode, strings.TrimSpace(string(resp.Stderr)))
+	}
+	return "", nil
+}
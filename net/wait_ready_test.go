@@ -0,0 +1,71 @@
+package net_test
+
+import (
+	"context"
+	stdnet "net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/designinlife/slib/net"
+)
+
+func TestWaitReady(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv)
+	marker := filepath.Join(srv.Root, "boot-finished")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	err := c.WaitReady(ctx, "test -f "+marker, 50*time.Millisecond)
+	var readyErr *net.ReadyError
+	require.ErrorAs(t, err, &readyErr)
+	assert.Equal(t, net.ReadyStageCommand, readyErr.Stage)
+	assert.Greater(t, readyErr.Attempts, 1)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = os.WriteFile(marker, nil, 0o644)
+	}()
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel2()
+	assert.NoError(t, c.WaitReady(ctx2, "test -f "+marker, 50*time.Millisecond))
+}
+
+func TestWaitReadyBannerTimeout(t *testing.T) {
+	// no dial timeout still reads the banner
+	srv := newTestServer(t)
+	c := newTestClient(t, srv, net.WithDialTimeout(0))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, c.WaitReady(ctx, "", 50*time.Millisecond))
+
+	// a server that never sends its banner is given up on when ctx is done
+	l, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err1 := l.Accept()
+			if err1 != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	addr := l.Addr().(*stdnet.TCPAddr)
+	silent := net.NewRichSSHClient(addr.IP.String(), addr.Port, "tester", net.WithPassword("secret"))
+	defer silent.Close()
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel2()
+	start := time.Now()
+	err = silent.WaitReady(ctx2, "", 50*time.Millisecond)
+	var readyErr *net.ReadyError
+	require.ErrorAs(t, err, &readyErr)
+	assert.Equal(t, net.ReadyStageBanner, readyErr.Stage)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}